@@ -16,8 +16,21 @@ func main() {
 		gologger.Fatal().Msgf("Could not create runner: %s\n", err)
 	}
 
-	err = newRunner.RunEnumeration()
-	if err != nil {
-		gologger.Fatal().Msgf("Could not run enumeration: %s\n", err)
+	switch options.Mode {
+	case runner.ModeMonitor:
+		err = newRunner.RunMonitor()
+		if err != nil {
+			gologger.Fatal().Msgf("Could not run monitor: %s\n", err)
+		}
+	case runner.ModeServe:
+		err = newRunner.RunServer()
+		if err != nil {
+			gologger.Fatal().Msgf("Could not run server: %s\n", err)
+		}
+	default:
+		err = newRunner.RunEnumeration()
+		if err != nil {
+			gologger.Fatal().Msgf("Could not run enumeration: %s\n", err)
+		}
 	}
 }
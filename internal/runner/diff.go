@@ -0,0 +1,304 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/projectdiscovery/gologger"
+
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// Kinds of changes reported in diff mode
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+)
+
+// sqliteMagic is the header every sqlite database file starts with
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// previousState is what a previous run found, per input and per tenant
+type previousState struct {
+	// inputs maps every input to the domains and findings found for it and the source of each
+	inputs map[string]map[resultKey]string
+	// tenants maps every tenant to the domains found for any of its inputs and their source
+	tenants map[string]map[string]string
+	// inputTenants maps every input to the tenant it was found in
+	inputTenants map[string]string
+}
+
+// resultKey identifies a domain or finding of an input, domains having the domain type
+type resultKey struct {
+	Type  string
+	Value string
+}
+
+// resultChange is a domain or finding added to or removed from a tenant or
+// input since the previous run
+type resultChange struct {
+	Type   string
+	Value  string
+	Source string
+	Tenant string
+	Change string
+}
+
+// loadPreviousState reads the state of a previous run either from
+// its jsonl output or from a sqlite history database
+func loadPreviousState(path string) (*previousState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var inputs map[string]map[resultKey]string
+	reader := bufio.NewReader(file)
+	header, _ := reader.Peek(len(sqliteMagic))
+	if bytes.Equal(header, sqliteMagic) {
+		inputs, err = loadSQLiteState(path)
+	} else {
+		inputs, err = loadJSONLState(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newPreviousState(inputs), nil
+}
+
+// newPreviousState indexes the results of every input by the tenant they were found in
+func newPreviousState(inputs map[string]map[resultKey]string) *previousState {
+	state := &previousState{
+		inputs:       inputs,
+		tenants:      make(map[string]map[string]string),
+		inputTenants: make(map[string]string),
+	}
+	for input, results := range inputs {
+		domains := make(map[string]string)
+		for key, sourceName := range results {
+			if key.Type == source.Domain.String() {
+				domains[key.Value] = sourceName
+			}
+		}
+		tenant := tenantName(input, domains)
+		state.inputTenants[input] = tenant
+		if _, ok := state.tenants[tenant]; !ok {
+			state.tenants[tenant] = make(map[string]string)
+		}
+		for domain, sourceName := range domains {
+			state.tenants[tenant][domain] = sourceName
+		}
+	}
+	return state
+}
+
+// errDiffBaseline is returned for the output of a previous diff, which only
+// holds the changes of its run and not the domains left as they were
+var errDiffBaseline = errors.New("the output of a diff only holds changes, diff against the full jsonl output of a run or a sqlite history instead")
+
+// loadJSONLState reads a previous run from -jsonl output, with or without -cs
+func loadJSONLState(reader io.Reader) (map[string]map[resultKey]string, error) {
+	inputs := make(map[string]map[resultKey]string)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var record struct {
+			Domain  string   `json:"domain"`
+			Input   string   `json:"input"`
+			Source  string   `json:"source"`
+			Sources []string `json:"sources"`
+			Type    string   `json:"type"`
+			Value   string   `json:"value"`
+			Change  string   `json:"change"`
+		}
+		if err := jsoniter.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		if record.Source == "" && len(record.Sources) > 0 {
+			record.Source = record.Sources[0]
+		}
+
+		var key resultKey
+		switch {
+		case record.Input == "":
+			continue
+		case record.Domain != "":
+			key = resultKey{Type: source.Domain.String(), Value: record.Domain}
+		case record.Type != "" && record.Value != "":
+			key = resultKey{Type: record.Type, Value: record.Value}
+		default:
+			continue
+		}
+
+		if record.Change != "" {
+			return nil, errDiffBaseline
+		}
+		if _, ok := inputs[record.Input]; !ok {
+			inputs[record.Input] = make(map[resultKey]string)
+		}
+		inputs[record.Input][key] = record.Source
+	}
+	return inputs, scanner.Err()
+}
+
+// differ reports the changes of a run against the previous state. Domains
+// are compared per tenant, so the inputs of a tenant changing between runs
+// do not report its domains, while findings depend on the input and are
+// compared per input.
+type differ struct {
+	previous *previousState
+	// tenants holds the tenants enumerated during the run in order
+	tenants []*tenantChanges
+	byName  map[string]*tenantChanges
+}
+
+// tenantChanges tracks the domains of a tenant found during the run
+type tenantChanges struct {
+	name string
+	// input is the first input of the tenant during the run, which its removed domains are written for
+	input   string
+	current map[string]struct{}
+	// failed is set when the enumeration of an input of the tenant failed
+	failed bool
+}
+
+func newDiffer(previous *previousState) *differ {
+	return &differ{previous: previous, byName: make(map[string]*tenantChanges)}
+}
+
+func (d *differ) tenant(name, input string) *tenantChanges {
+	tenant, ok := d.byName[name]
+	if !ok {
+		tenant = &tenantChanges{name: name, input: input, current: make(map[string]struct{})}
+		d.byName[name] = tenant
+		d.tenants = append(d.tenants, tenant)
+	}
+	return tenant
+}
+
+// diff returns the domains of the input new to its tenant and not reported
+// for another input of the run yet, along with the findings added to and
+// removed from the input. Removed domains are only known once every input
+// of the tenant ran and are returned by removed.
+func (d *differ) diff(result *inputResult) []resultChange {
+	name := tenantName(result.Input, result.Hosts)
+	// A failed enumeration tells no tenant, the one of the previous run is kept as it was
+	if result.failed() {
+		if previous, ok := d.previous.inputTenants[result.Input]; ok {
+			name = previous
+		}
+	}
+	tenant := d.tenant(name, result.Input)
+	if result.failed() {
+		tenant.failed = true
+	} else if previous, ok := d.previous.inputTenants[result.Input]; ok && previous != name {
+		// The tenant the input left is compared too, its domains being removed
+		// when no other input of the run is found in it anymore
+		d.tenant(previous, result.Input)
+	}
+
+	var changes []resultChange
+	for host, entry := range result.Hosts {
+		if _, ok := tenant.current[host]; ok {
+			continue
+		}
+		tenant.current[host] = struct{}{}
+		if _, ok := d.previous.tenants[name][host]; !ok {
+			changes = append(changes, resultChange{Type: source.Domain.String(), Value: host, Source: entry.Source, Tenant: name, Change: changeAdded})
+		}
+	}
+
+	previous := make(map[resultKey]string)
+	for key, sourceName := range d.previous.inputs[result.Input] {
+		if key.Type != source.Domain.String() {
+			previous[key] = sourceName
+		}
+	}
+	current := make(map[resultKey]string, len(result.Findings))
+	for _, finding := range result.Findings {
+		key := resultKey{Type: finding.Type.String(), Value: finding.Value}
+		if _, ok := current[key]; !ok {
+			current[key] = finding.Source
+		}
+	}
+	for key, source := range current {
+		if _, ok := previous[key]; !ok {
+			changes = append(changes, resultChange{Type: key.Type, Value: key.Value, Source: source, Tenant: name, Change: changeAdded})
+		}
+	}
+
+	// A failed enumeration would otherwise report every known finding as removed
+	if result.failed() {
+		if len(previous) > 0 || len(d.previous.tenants[name]) > 0 {
+			gologger.Warning().Msgf("Not reporting removed domains for %s as the enumeration failed\n", result.Input)
+		}
+	} else {
+		for key, source := range previous {
+			if _, ok := current[key]; !ok {
+				changes = append(changes, resultChange{Type: key.Type, Value: key.Value, Source: source, Tenant: name, Change: changeRemoved})
+			}
+		}
+	}
+	sortChanges(changes)
+	return changes
+}
+
+// tenantRemoval is the domains removed from a tenant, written for its first input
+type tenantRemoval struct {
+	input   string
+	changes []resultChange
+}
+
+// removed returns the domains of the previous run missing from every input
+// of their tenant during this run, leaving out the tenants an input failed for
+func (d *differ) removed() []tenantRemoval {
+	var removals []tenantRemoval
+	for _, tenant := range d.tenants {
+		if tenant.failed {
+			continue
+		}
+		var changes []resultChange
+		for host, sourceName := range d.previous.tenants[tenant.name] {
+			if _, ok := tenant.current[host]; !ok {
+				changes = append(changes, resultChange{Type: source.Domain.String(), Value: host, Source: sourceName, Tenant: tenant.name, Change: changeRemoved})
+			}
+		}
+		if len(changes) > 0 {
+			sortChanges(changes)
+			removals = append(removals, tenantRemoval{input: tenant.input, changes: changes})
+		}
+	}
+	return removals
+}
+
+// sortChanges orders the changes by kind, then lists the domains before the findings
+func sortChanges(changes []resultChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		if changes[i].isDomain() != changes[j].isDomain() {
+			return changes[i].isDomain()
+		}
+		if changes[i].Type != changes[j].Type {
+			return changes[i].Type < changes[j].Type
+		}
+		return changes[i].Value < changes[j].Value
+	})
+}
+
+func (c resultChange) isDomain() bool {
+	return c.Type == source.Domain.String()
+}
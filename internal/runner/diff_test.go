@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+func domainKey(domain string) resultKey {
+	return resultKey{Type: source.Domain.String(), Value: domain}
+}
+
+func TestLoadJSONLState(t *testing.T) {
+	tests := []struct {
+		name  string
+		jsonl string
+		want  map[string]map[resultKey]string
+	}{
+		{
+			name: "jsonl output",
+			jsonl: `{"domain":"contoso.com","input":"contoso.com","source":"aad","status":"ok"}
+{"input":"contoso.com","source":"autodiscover","type":"deployment","value":"hybrid","status":"ok"}
+{"input":"example.org","status":"not_in_tenant"}`,
+			want: map[string]map[resultKey]string{
+				"contoso.com": {
+					domainKey("contoso.com"):              "aad",
+					{Type: "deployment", Value: "hybrid"}: "autodiscover",
+				},
+			},
+		},
+		{
+			name:  "capture sources output",
+			jsonl: `{"domain":"contoso.com","input":"contoso.com","sources":["aad","crtsh"]}`,
+			want: map[string]map[resultKey]string{
+				"contoso.com": {domainKey("contoso.com"): "aad"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := loadJSONLState(strings.NewReader(test.jsonl))
+			if err != nil {
+				t.Fatalf("could not load state: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("state = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadJSONLStateRejectsDiffOutput(t *testing.T) {
+	jsonl := `{"domain":"new.contoso.com","input":"contoso.com","tenant":"contoso.onmicrosoft.com","source":"aad","change":"added"}`
+	if _, err := loadJSONLState(strings.NewReader(jsonl)); !errors.Is(err, errDiffBaseline) {
+		t.Errorf("error = %v, want %v", err, errDiffBaseline)
+	}
+}
+
+func diffResult(input, status string, hosts []string, findings ...source.Result) *inputResult {
+	result := &inputResult{Input: input, Status: status, Hosts: make(map[string]resolve.HostEntry), Findings: findings}
+	for _, host := range hosts {
+		result.Hosts[host] = resolve.HostEntry{Domain: input, Host: host, Source: "aad"}
+	}
+	return result
+}
+
+func TestDiffer(t *testing.T) {
+	previous := newPreviousState(map[string]map[resultKey]string{
+		"contoso.com": {
+			domainKey("contoso.com"):                   "aad",
+			domainKey("contoso.onmicrosoft.com"):       "aad",
+			domainKey("old.contoso.com"):               "aad",
+			{Type: "deployment", Value: "on-premises"}: "autodiscover",
+		},
+		"fabrikam.com": {
+			domainKey("fabrikam.com"):             "aad",
+			domainKey("fabrikam.onmicrosoft.com"): "aad",
+		},
+	})
+
+	tests := []struct {
+		name    string
+		results []*inputResult
+		changes [][]resultChange
+		removed []tenantRemoval
+	}{
+		{
+			name: "domains of the tenant found for another input are not added",
+			results: []*inputResult{
+				diffResult("contoso.net", statusOK, []string{"contoso.com", "contoso.net", "contoso.onmicrosoft.com", "old.contoso.com"}),
+			},
+			changes: [][]resultChange{{
+				{Type: "domain", Value: "contoso.net", Source: "aad", Tenant: "contoso.onmicrosoft.com", Change: changeAdded},
+			}},
+		},
+		{
+			name: "domains are added once per tenant and removed after the run",
+			results: []*inputResult{
+				diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com", "new.contoso.com"}),
+				diffResult("contoso.net", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com", "new.contoso.com"}),
+			},
+			changes: [][]resultChange{
+				{
+					{Type: "domain", Value: "new.contoso.com", Source: "aad", Tenant: "contoso.onmicrosoft.com", Change: changeAdded},
+					{Type: "deployment", Value: "on-premises", Source: "autodiscover", Tenant: "contoso.onmicrosoft.com", Change: changeRemoved},
+				},
+				nil,
+			},
+			removed: []tenantRemoval{{input: "contoso.com", changes: []resultChange{
+				{Type: "domain", Value: "old.contoso.com", Source: "aad", Tenant: "contoso.onmicrosoft.com", Change: changeRemoved},
+			}}},
+		},
+		{
+			name: "findings are compared per input",
+			results: []*inputResult{
+				diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com", "old.contoso.com"},
+					source.Result{Source: "autodiscover", Type: source.Deployment, Value: "hybrid"}),
+			},
+			changes: [][]resultChange{{
+				{Type: "deployment", Value: "hybrid", Source: "autodiscover", Tenant: "contoso.onmicrosoft.com", Change: changeAdded},
+				{Type: "deployment", Value: "on-premises", Source: "autodiscover", Tenant: "contoso.onmicrosoft.com", Change: changeRemoved},
+			}},
+		},
+		{
+			name: "a failed input keeps its tenant from being reported as removed",
+			results: []*inputResult{
+				diffResult("fabrikam.net", statusOK, []string{"fabrikam.net", "fabrikam.onmicrosoft.com"}),
+				diffResult("fabrikam.com", statusError, nil),
+			},
+			changes: [][]resultChange{
+				{{Type: "domain", Value: "fabrikam.net", Source: "aad", Tenant: "fabrikam.onmicrosoft.com", Change: changeAdded}},
+				nil,
+			},
+		},
+		{
+			name: "domains of the tenant an input left are removed",
+			results: []*inputResult{
+				diffResult("fabrikam.com", statusNotInTenant, nil),
+			},
+			changes: [][]resultChange{nil},
+			removed: []tenantRemoval{{input: "fabrikam.com", changes: []resultChange{
+				{Type: "domain", Value: "fabrikam.com", Source: "aad", Tenant: "fabrikam.onmicrosoft.com", Change: changeRemoved},
+				{Type: "domain", Value: "fabrikam.onmicrosoft.com", Source: "aad", Tenant: "fabrikam.onmicrosoft.com", Change: changeRemoved},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			differ := newDiffer(previous)
+			for i, result := range test.results {
+				if got := differ.diff(result); !reflect.DeepEqual(got, test.changes[i]) {
+					t.Errorf("changes of %s = %+v, want %+v", result.Input, got, test.changes[i])
+				}
+			}
+			if got := differ.removed(); !reflect.DeepEqual(got, test.removed) {
+				t.Errorf("removed = %+v, want %+v", got, test.removed)
+			}
+		})
+	}
+}
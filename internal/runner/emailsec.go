@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/ratelimit"
+
+	"github.com/upmux/tenantfinder/pkg/emailsec"
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/session"
+)
+
+const (
+	// emailSecurityWorkers is the number of domains checked concurrently
+	emailSecurityWorkers = 10
+	// emailSecuritySource names the mta-sts requests in the rate limits, metrics and har
+	emailSecuritySource = "email-security"
+)
+
+// newEmailChecker creates the checker of the email security posture, querying
+// dns with the resolver of the agent and fetching the mta-sts policies through
+// a session sharing the proxies, tls settings and har of the sources
+func (r *Runner) newEmailChecker() (*emailsec.Checker, error) {
+	keys := []string{emailSecuritySource}
+	if r.proxyPool != nil && r.proxyPool.PerProxyRateLimit {
+		keys = keys[:0]
+		for _, proxyURL := range r.proxyPool.Proxies() {
+			keys = append(keys, r.proxyPool.RateLimitKey(emailSecuritySource, proxyURL))
+		}
+	}
+
+	var multiRateLimiter *ratelimit.MultiLimiter
+	for _, key := range keys {
+		rateLimitOptions := &ratelimit.Options{Key: key, IsUnlimited: true, MaxCount: math.MaxUint32, Duration: time.Millisecond}
+		if r.options.RateLimit > 0 {
+			rateLimitOptions = &ratelimit.Options{Key: key, MaxCount: uint(r.options.RateLimit), Duration: time.Second}
+		}
+
+		var err error
+		if multiRateLimiter == nil {
+			multiRateLimiter, err = ratelimit.NewMultiLimiter(context.Background(), rateLimitOptions)
+		} else {
+			err = multiRateLimiter.Add(rateLimitOptions)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sess := session.NewSession("", "", multiRateLimiter, r.options.Timeout)
+	sess.Retries = r.options.Retries
+	sess.ProxyPool = r.proxyPool
+	sess.SetTLSConfig(r.tlsConfig)
+	if r.replay != nil {
+		sess.SetHARReplay(r.replay)
+	}
+	if r.har != nil {
+		sess.SetHARRecorder(r.har)
+	}
+	resolver, err := r.agent.Resolver()
+	if err != nil {
+		return nil, err
+	}
+	return emailsec.NewChecker(resolver, sess), nil
+}
+
+// checkEmailSecurity checks the email security posture of every found domain
+func (r *Runner) checkEmailSecurity(ctx context.Context, hosts map[string]resolve.HostEntry) map[string]*emailsec.Posture {
+	gologger.Verbose().Msgf("Checking the email security of %d domains\n", len(hosts))
+	ctx = context.WithValue(ctx, session.CtxSourceArg, emailSecuritySource)
+
+	postures := make(map[string]*emailsec.Posture, len(hosts))
+	mutex := &sync.Mutex{}
+	domains := make(chan string)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < emailSecurityWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range domains {
+				posture := r.emailChecker.Check(ctx, domain)
+				mutex.Lock()
+				postures[domain] = posture
+				mutex.Unlock()
+			}
+		}()
+	}
+	for domain := range hosts {
+		if ctx.Err() != nil {
+			break
+		}
+		domains <- domain
+	}
+	close(domains)
+	wg.Wait()
+	return postures
+}
+
+// summarizeEmail summarizes the email security posture of a domain for the report
+func summarizeEmail(domain string, posture *emailsec.Posture) reportEmail {
+	summary := reportEmail{
+		Domain:    domain,
+		SPF:       "missing",
+		DMARC:     "missing",
+		MTASTS:    "missing",
+		TLSRPT:    "missing",
+		DKIM:      "missing",
+		Spoofable: posture.Spoofable,
+	}
+
+	if spf := posture.SPF; spf != nil {
+		summary.SPF = fmt.Sprintf("%s, %d lookups", spf.All, spf.Lookups)
+		if spf.All == "" {
+			summary.SPF = fmt.Sprintf("no all, %d lookups", spf.Lookups)
+		}
+		if len(spf.Errors) > 0 {
+			summary.SPF += ", " + strings.Join(spf.Errors, ", ")
+		}
+	}
+	if dmarc := posture.DMARC; dmarc != nil {
+		summary.DMARC = "p=" + dmarc.Policy
+		if dmarc.SubdomainPolicy != "" {
+			summary.DMARC += " sp=" + dmarc.SubdomainPolicy
+		}
+		if dmarc.Percentage != 100 {
+			summary.DMARC += fmt.Sprintf(" pct=%d", dmarc.Percentage)
+		}
+		if dmarc.Domain != "" {
+			summary.DMARC += " from " + dmarc.Domain
+		}
+		if len(dmarc.RUA) > 0 {
+			summary.DMARC += ", rua " + strings.Join(dmarc.RUA, " ")
+		}
+		if len(dmarc.RUF) > 0 {
+			summary.DMARC += ", ruf " + strings.Join(dmarc.RUF, " ")
+		}
+	}
+	if mtaSTS := posture.MTASTS; mtaSTS != nil {
+		summary.MTASTS = mtaSTS.Mode
+		if mtaSTS.Error != "" {
+			summary.MTASTS = "no policy: " + mtaSTS.Error
+		}
+	}
+	if tlsRPT := posture.TLSRPT; tlsRPT != nil {
+		summary.TLSRPT = strings.Join(tlsRPT.RUA, " ")
+	}
+	if len(posture.DKIM) > 0 {
+		selectors := make([]string, 0, len(posture.DKIM))
+		for _, dkim := range posture.DKIM {
+			selectors = append(selectors, dkim.Selector)
+		}
+		summary.DKIM = strings.Join(selectors, ", ")
+	}
+	return summary
+}
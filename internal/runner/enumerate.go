@@ -2,6 +2,8 @@ package runner
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"sync"
@@ -12,7 +14,10 @@ import (
 	"github.com/projectdiscovery/gologger"
 
 	"github.com/upmux/tenantfinder/pkg/agent"
+	"github.com/upmux/tenantfinder/pkg/emailsec"
+	"github.com/upmux/tenantfinder/pkg/metrics"
 	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/session"
 	"github.com/upmux/tenantfinder/pkg/source"
 )
 
@@ -36,8 +41,83 @@ func (r *Runner) EnumerateSingleDomain(domain string, writers []io.Writer) (map[
 func (r *Runner) EnumerateSingleDomainWithCtx(ctx context.Context, domain string, writers []io.Writer) (map[string]map[string]struct{}, error) {
 	gologger.Info().Msgf("Enumerating domains for %s\n", domain)
 
+	result := r.enumerateDomain(ctx, domain)
+
+	outputWriter := NewOutputWriter(r.options.JSON)
+	outputWriter.Template = r.template
+	outputWriter.StatusLines = r.options.StatusLines
+	outputWriter.PlainFindings = r.options.PlainFindings
+	// Now output all results in output writers
+	var err error
+	var changes []resultChange
+	if r.differ != nil {
+		changes = r.differ.diff(result)
+	}
+	for _, writer := range writers {
+		if r.differ != nil {
+			err = outputWriter.WriteChangedHost(domain, changes, writer)
+		} else if outputWriter.Template != nil {
+			err = outputWriter.WriteTemplateHost(result, writer)
+		} else if r.options.CaptureSources {
+			err = outputWriter.WriteSourceHost(result, writer)
+		} else {
+			err = outputWriter.WriteHost(result, writer)
+		}
+
+		if err != nil {
+			gologger.Error().Msgf("Could not write results for %s: %s\n", domain, err)
+			return nil, err
+		}
+	}
+	// }
+
+	duration := durafmt.Parse(result.Duration).LimitFirstN(maxNumCount).String()
+	numberOfSubDomains := len(result.Hosts)
+
+	if result.Status == statusOK {
+		gologger.Info().Msgf("Found %d domains for %s in %s\n", numberOfSubDomains, domain, duration)
+	} else {
+		gologger.Info().Msgf("Found %d domains for %s in %s (%s)\n", numberOfSubDomains, domain, duration, result.Status)
+	}
+
+	if r.options.Statistics {
+		gologger.Info().Msgf("Printing source statistics for %s", domain)
+		printStatistics(result.Statistics)
+	}
+
+	r.collect(ctx, result)
+
+	return result.Sources, nil
+}
+
+// enumerateDomain runs the agent against a single domain and collects its results
+func (r *Runner) enumerateDomain(ctx context.Context, domain string) *inputResult {
+	metrics.InputStarted()
+	defer metrics.InputFinished()
+
 	now := time.Now()
-	results := r.agent.EnumerateDomains(domain, r.options.Proxy, r.options.RateLimit, r.options.Timeout, time.Duration(r.options.MaxEnumerationTime)*time.Minute, agent.WithCustomRateLimit(r.rateLimit))
+	enumerateOptions := []agent.EnumerateOption{agent.WithCustomRateLimit(r.rateLimit), agent.WithRetries(r.options.Retries), agent.WithTLSConfig(r.tlsConfig)}
+	if r.multiRateLimiter != nil {
+		enumerateOptions = append(enumerateOptions, agent.WithMultiRateLimiter(r.multiRateLimiter))
+	}
+	if r.proxyPool != nil {
+		enumerateOptions = append(enumerateOptions, agent.WithProxyPool(r.proxyPool))
+	}
+	if r.har != nil {
+		enumerateOptions = append(enumerateOptions, agent.WithHARRecorder(r.har))
+	}
+	if r.replay != nil {
+		enumerateOptions = append(enumerateOptions, agent.WithHARReplay(r.replay))
+	}
+	if r.options.NoVerifyHosts {
+		enumerateOptions = append(enumerateOptions, agent.WithoutHostVerification())
+	}
+	// The monitor tells when a tenant switches between managed and federated
+	if r.options.Mode == ModeMonitor {
+		enumerateOptions = append(enumerateOptions, agent.WithAuthentication())
+	}
+	// The proxies are rotated by the pool
+	results := r.agent.EnumerateDomainsWithCtx(ctx, domain, "", r.options.RateLimit, r.options.Timeout, time.Duration(r.options.MaxEnumerationTime)*time.Minute, enumerateOptions...)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
@@ -45,13 +125,37 @@ func (r *Runner) EnumerateSingleDomainWithCtx(ctx context.Context, domain string
 	uniqueMap := make(map[string]resolve.HostEntry)
 	// Create a map to track sources for each host
 	sourceMap := make(map[string]map[string]struct{})
+	// Create a map to track federation issuers and their endpoints
+	issuerMap := make(map[string]string)
+	// Create a map to track the typed findings of the sources, such as service endpoints
+	findingMap := make(map[findingKey]struct{})
+	var findings []source.Result
+	// authentication is set when it was looked up along with the sources
+	var authentication string
+	var errs []string
+	// noTenant is set when a source found the input does not belong to any tenant
+	var noTenant, throttled bool
 	skippedCounts := make(map[string]int)
 	// Process the results in a separate goroutine
 	go func() {
 		for result := range results {
 			switch result.Type {
 			case source.Error:
+				if errors.Is(result.Error, source.ErrNoTenant) {
+					gologger.Verbose().Msgf("Source %s found %s does not belong to a tenant: %s\n", result.Source, domain, result.Error)
+					noTenant = true
+					continue
+				}
+				if errors.Is(result.Error, source.ErrNoResults) {
+					gologger.Verbose().Msgf("Source %s found nothing for %s: %s\n", result.Source, domain, result.Error)
+					continue
+				}
+				if errors.Is(result.Error, source.ErrThrottled) {
+					throttled = true
+				}
 				gologger.Warning().Msgf("Encountered an error with source %s: %s\n", result.Source, result.Error)
+				errs = append(errs, fmt.Sprintf("%s: %s", result.Source, result.Error))
+				metrics.ObserveError(result.Source, session.ErrorClass(result.Error))
 			case source.Domain:
 				tenantDomain := replacer.Replace(result.Value)
 				tenantDomain = preprocessDomain(tenantDomain)
@@ -62,12 +166,28 @@ func (r *Runner) EnumerateSingleDomainWithCtx(ctx context.Context, domain string
 
 				sourceMap[tenantDomain][result.Source] = struct{}{}
 
-				if _, ok := uniqueMap[tenantDomain]; ok {
+				if entry, ok := uniqueMap[tenantDomain]; ok {
+					// Keep where the domain was found when the first source did not tell
+					if entry.Reference == "" && result.Reference != "" {
+						entry.Reference = result.Reference
+						uniqueMap[tenantDomain] = entry
+					}
 					skippedCounts[result.Source]++
 					continue
 				}
-				hostEntry := resolve.HostEntry{Domain: domain, Host: tenantDomain, Source: result.Source}
+				hostEntry := resolve.HostEntry{Domain: domain, Host: tenantDomain, Source: result.Source, Reference: result.Reference}
 				uniqueMap[tenantDomain] = hostEntry
+			case source.Issuer:
+				issuerMap[result.Value] = result.Reference
+			case source.Authentication:
+				authentication = result.Value
+			default:
+				key := findingKey{Type: result.Type, Source: result.Source, Value: result.Value, Reference: result.Reference}
+				if _, ok := findingMap[key]; ok {
+					continue
+				}
+				findingMap[key] = struct{}{}
+				findings = append(findings, result)
 			}
 		}
 		// Close the task channel only if wildcards are asked to be removed
@@ -75,42 +195,120 @@ func (r *Runner) EnumerateSingleDomainWithCtx(ctx context.Context, domain string
 	}()
 
 	wg.Wait()
-	outputWriter := NewOutputWriter(r.options.JSON)
-	// Now output all results in output writers
-	var err error
-	for _, writer := range writers {
-		if r.options.CaptureSources {
-			err = outputWriter.WriteSourceHost(domain, sourceMap, writer)
-		} else {
-			err = outputWriter.WriteHost(domain, uniqueMap, writer)
-		}
 
-		if err != nil {
-			gologger.Error().Msgf("Could not write results for %s: %s\n", domain, err)
-			return nil, err
+	statistics := r.agent.GetStatistics()
+	// This is a hack to remove the skipped count from the statistics
+	// as we don't want to show it in the statistics.
+	// TODO: Design a better way to do this.
+	for source, count := range skippedCounts {
+		if stat, ok := statistics[source]; ok {
+			stat.Results -= count
+			statistics[source] = stat
+		}
+	}
+	for source, stat := range statistics {
+		if !stat.Skipped {
+			metrics.ObserveSource(source, stat.Results, stat.TimeTaken)
 		}
 	}
-	// }
 
-	duration := durafmt.Parse(time.Since(now)).LimitFirstN(maxNumCount).String()
-	numberOfSubDomains := len(uniqueMap)
+	sortFindings(findings)
 
-	gologger.Info().Msgf("Found %d domains for %s in %s\n", numberOfSubDomains, domain, duration)
+	var email map[string]*emailsec.Posture
+	if r.emailChecker != nil && len(uniqueMap) > 0 {
+		email = r.checkEmailSecurity(ctx, uniqueMap)
+	}
+	var ips map[string][]string
+	if r.ipResolver != nil && len(uniqueMap) > 0 {
+		ips = resolveIPs(ctx, r.ipResolver, uniqueMap)
+	}
 
-	if r.options.Statistics {
-		gologger.Info().Msgf("Printing source statistics for %s", domain)
-		statistics := r.agent.GetStatistics()
-		// This is a hack to remove the skipped count from the statistics
-		// as we don't want to show it in the statistics.
-		// TODO: Design a better way to do this.
-		for source, count := range skippedCounts {
-			if stat, ok := statistics[source]; ok {
-				stat.Results -= count
-				statistics[source] = stat
-			}
+	return &inputResult{
+		Input:          domain,
+		Hosts:          uniqueMap,
+		Sources:        sourceMap,
+		Issuers:        issuerMap,
+		Authentication: authentication,
+		Findings:       findings,
+		Email:          email,
+		IPs:            ips,
+		Errors:         errs,
+		Status:         inputStatus(len(uniqueMap)+len(findings), noTenant, throttled, len(errs)),
+		Duration:       time.Since(now),
+		Statistics:     statistics,
+	}
+}
+
+// inputResult contains everything collected for a single input
+type inputResult struct {
+	Input   string
+	Hosts   map[string]resolve.HostEntry
+	Sources map[string]map[string]struct{}
+	Issuers map[string]string
+	// Authentication is managed or federated when it was looked up
+	Authentication string
+	Findings       []source.Result
+	// Email holds the email security posture of the domains when it was checked
+	Email map[string]*emailsec.Posture
+	// IPs holds the addresses of the domains when the output template uses them
+	IPs        map[string][]string
+	Errors     []string
+	Status     string
+	Duration   time.Duration
+	Statistics map[string]source.Statistics
+}
+
+// failed reports whether the enumeration of the input failed without any result
+func (result *inputResult) failed() bool {
+	return result.Status == statusError || result.Status == statusThrottled
+}
+
+// collect hands the result of an input over to the run-wide collectors
+func (r *Runner) collect(ctx context.Context, result *inputResult) {
+	if r.graph != nil {
+		r.graph.add(result)
+	}
+	if r.report != nil {
+		r.report.add(result)
+	}
+	if r.failed != nil && result.failed() {
+		if _, err := fmt.Fprintln(r.failed, result.Input); err != nil {
+			gologger.Error().Msgf("Could not write %s to %s: %s\n", result.Input, r.options.FailedOutput, err)
 		}
-		printStatistics(statistics)
 	}
+	r.persist(ctx, result)
+}
+
+// persist hands the result of an input over to the sinks that outlive a run
+func (r *Runner) persist(ctx context.Context, result *inputResult) {
+	if r.webhook != nil {
+		if err := r.webhook.add(ctx, result); err != nil {
+			gologger.Error().Msgf("Could not post results for %s to %s: %s\n", result.Input, r.options.Webhook, err)
+		}
+	}
+	if r.store != nil {
+		if err := r.store.add(result); err != nil {
+			gologger.Error().Msgf("Could not store results for %s in %s: %s\n", result.Input, r.options.SQLite, err)
+		}
+	}
+}
+
+// flushWebhook posts the results still queued for the webhook, which the
+// long running modes do after every job or enumeration and on shutdown
+func (r *Runner) flushWebhook(ctx context.Context) {
+	if r.webhook == nil {
+		return
+	}
+	if err := r.webhook.flush(ctx); err != nil {
+		gologger.Error().Msgf("Could not post results to %s: %s\n", r.options.Webhook, err)
+	}
+}
+
+// flushWebhookOnShutdown posts the queued results once the run context is
+// done, giving the last requests a little time of their own
+func (r *Runner) flushWebhookOnShutdown(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webhookShutdownTimeout)
+	defer cancel()
 
-	return sourceMap, nil
+	r.flushWebhook(ctx)
 }
@@ -0,0 +1,439 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/robfig/cron/v3"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// Types of change events emitted in monitor mode
+const (
+	eventDomainAdded           = "domain_added"
+	eventDomainRemoved         = "domain_removed"
+	eventAuthenticationChanged = "authentication_changed"
+)
+
+// monitorEvent is a change to the known state of a tenant
+type monitorEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Input    string    `json:"input"`
+	Tenant   string    `json:"tenant"`
+	Domain   string    `json:"domain,omitempty"`
+	Previous string    `json:"previous,omitempty"`
+	Current  string    `json:"current,omitempty"`
+}
+
+// monitorState is the last known state of the tenants, kept in the state
+// file across restarts when one is given
+type monitorState struct {
+	Tenants map[string]*tenantState `json:"tenants"`
+	// Inputs maps every input to the tenant it was last found in
+	Inputs map[string]string `json:"inputs"`
+}
+
+// tenantState is the last known state of a tenant, merged from all its inputs
+type tenantState struct {
+	// Input is the first input the tenant was found for
+	Input          string              `json:"input"`
+	Hosts          map[string]struct{} `json:"hosts"`
+	Authentication string              `json:"authentication,omitempty"`
+}
+
+// eventWriter sends change events to stdout, a jsonl file and a webhook
+type eventWriter struct {
+	json    bool
+	output  io.Writer
+	file    *os.File
+	webhook *webhookClient
+	// webhookFormat is the payload format of the events posted to the webhook
+	webhookFormat string
+}
+
+// RunMonitor wraps RunMonitorWithCtx with an empty context
+func (r *Runner) RunMonitor() error {
+	return r.RunMonitorWithCtx(context.Background())
+}
+
+// RunMonitorWithCtx re-enumerates the inputs on the configured schedule and
+// emits an event for every change to the known state of their tenants
+func (r *Runner) RunMonitorWithCtx(ctx context.Context) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	domains, err := r.readInputs()
+	if err != nil {
+		return err
+	}
+
+	schedule, err := r.monitorSchedule()
+	if err != nil {
+		return err
+	}
+
+	events, err := newEventWriter(r.options)
+	if err != nil {
+		return err
+	}
+	defer events.close()
+
+	if r.har != nil {
+		defer r.writeHAR()
+	}
+	if r.store != nil {
+		defer func() {
+			if err := r.store.close(); err != nil {
+				gologger.Error().Msgf("Could not close sqlite database %s: %s\n", r.options.SQLite, err)
+			}
+		}()
+	}
+	// The results of an interrupted enumeration are still posted
+	defer r.flushWebhookOnShutdown(ctx)
+
+	state, err := loadMonitorState(r.options.MonitorState)
+	if err != nil {
+		return err
+	}
+	for {
+		if !r.monitorCycle(ctx, domains, state, events) {
+			return nil
+		}
+		if r.options.MonitorState != "" {
+			if err := state.save(r.options.MonitorState); err != nil {
+				gologger.Error().Msgf("Could not save monitor state to %s: %s\n", r.options.MonitorState, err)
+			}
+		}
+
+		next := schedule.Next(time.Now())
+		gologger.Info().Msgf("Next enumeration at %s\n", next.Format(time.RFC3339))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// monitorCycle enumerates every input and emits the changes to the last
+// known state of their tenants. It returns false when it was interrupted,
+// leaving the state untouched.
+func (r *Runner) monitorCycle(ctx context.Context, domains []string, state *monitorState, events *eventWriter) bool {
+	results := make([]*inputResult, 0, len(domains))
+	for _, domain := range domains {
+		if ctx.Err() != nil {
+			return false
+		}
+		gologger.Info().Msgf("Enumerating domains for %s\n", domain)
+
+		result := r.enumerateDomain(ctx, domain)
+		if result.failed() {
+			gologger.Warning().Msgf("Keeping the last known state of %s as the enumeration failed\n", domain)
+		} else {
+			r.persist(ctx, result)
+		}
+		results = append(results, result)
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	r.flushWebhook(ctx)
+
+	for _, event := range state.apply(results) {
+		if err := events.write(ctx, event); err != nil {
+			gologger.Error().Msgf("Could not send %s event for %s: %s\n", event.Type, event.Tenant, err)
+		}
+	}
+	return true
+}
+
+// apply merges the results of the inputs of each tenant into the state and
+// returns the changes to the last known state of the tenants. The tenants an
+// input left are compared too, so their domains are reported as removed when
+// no other input is found in them anymore.
+func (s *monitorState) apply(results []*inputResult) []monitorEvent {
+	current := make(map[string]*tenantState)
+	// incomplete holds the tenants an input failed for, whose state is kept as it was
+	incomplete := make(map[string]struct{})
+	// left maps the tenants inputs are no longer found in to one of these inputs
+	left := make(map[string]string)
+	for _, result := range results {
+		previous, known := s.Inputs[result.Input]
+		if result.failed() {
+			if known {
+				incomplete[previous] = struct{}{}
+			}
+			continue
+		}
+
+		// An input without domains, such as one outside any tenant, has no tenant
+		var tenant string
+		if len(result.Hosts) > 0 {
+			tenant = tenantName(result.Input, result.Hosts)
+		}
+		if known && previous != tenant {
+			left[previous] = result.Input
+		}
+		if tenant == "" {
+			delete(s.Inputs, result.Input)
+			continue
+		}
+		s.Inputs[result.Input] = tenant
+
+		merged, ok := current[tenant]
+		if !ok {
+			merged = &tenantState{Input: result.Input, Hosts: make(map[string]struct{})}
+			current[tenant] = merged
+		}
+		for host := range result.Hosts {
+			merged.Hosts[host] = struct{}{}
+		}
+		if merged.Authentication == "" {
+			merged.Authentication = result.Authentication
+		}
+	}
+	for tenant, input := range left {
+		if _, ok := current[tenant]; !ok {
+			current[tenant] = &tenantState{Input: input, Hosts: make(map[string]struct{})}
+		}
+	}
+
+	tenants := make([]string, 0, len(current))
+	for tenant := range current {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	var events []monitorEvent
+	for _, tenant := range tenants {
+		if _, ok := incomplete[tenant]; ok {
+			continue
+		}
+		merged := current[tenant]
+		previous, ok := s.Tenants[tenant]
+		// The first enumeration only establishes the baseline
+		if !ok {
+			s.Tenants[tenant] = merged
+			gologger.Info().Msgf("Recorded %d domains for %s\n", len(merged.Hosts), tenant)
+			continue
+		}
+		// Keep the known authentication when it could not be looked up this time
+		if merged.Authentication == "" {
+			merged.Authentication = previous.Authentication
+		}
+		s.Tenants[tenant] = merged
+
+		events = append(events, stateChanges(tenant, previous, merged)...)
+	}
+	return events
+}
+
+// stateChanges returns the events turning the previous into the current state of a tenant
+func stateChanges(tenant string, previous, current *tenantState) []monitorEvent {
+	now := time.Now().UTC()
+	input := current.Input
+
+	var events []monitorEvent
+	for host := range current.Hosts {
+		if _, ok := previous.Hosts[host]; !ok {
+			events = append(events, monitorEvent{Time: now, Type: eventDomainAdded, Input: input, Tenant: tenant, Domain: host})
+		}
+	}
+	for host := range previous.Hosts {
+		if _, ok := current.Hosts[host]; !ok {
+			events = append(events, monitorEvent{Time: now, Type: eventDomainRemoved, Input: input, Tenant: tenant, Domain: host})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Type != events[j].Type {
+			return events[i].Type < events[j].Type
+		}
+		return events[i].Domain < events[j].Domain
+	})
+
+	if previous.Authentication != "" && current.Authentication != "" && previous.Authentication != current.Authentication {
+		events = append(events, monitorEvent{
+			Time:     now,
+			Type:     eventAuthenticationChanged,
+			Input:    input,
+			Tenant:   tenant,
+			Previous: previous.Authentication,
+			Current:  current.Authentication,
+		})
+	}
+	return events
+}
+
+// loadMonitorState reads the state saved by a previous monitor, or starts
+// from an empty one when there is no state file yet
+func loadMonitorState(path string) (*monitorState, error) {
+	state := &monitorState{Tenants: make(map[string]*tenantState), Inputs: make(map[string]string)}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := jsoniter.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("could not parse monitor state %s: %w", path, err)
+	}
+	if state.Tenants == nil {
+		state.Tenants = make(map[string]*tenantState)
+	}
+	if state.Inputs == nil {
+		state.Inputs = make(map[string]string)
+	}
+	return state, nil
+}
+
+// save writes the state through a temporary file, so an interrupted write
+// never leaves a truncated state behind
+func (s *monitorState) save(path string) error {
+	data, err := jsoniter.Marshal(s)
+	if err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
+
+// monitorSchedule returns the schedule given by the cron expression or interval
+func (r *Runner) monitorSchedule() (cron.Schedule, error) {
+	if r.options.MonitorCron != "" {
+		return cron.ParseStandard(r.options.MonitorCron)
+	}
+	return cron.Every(r.options.MonitorInterval), nil
+}
+
+// readInputs reads all the domains given on the command line or stdin
+func (r *Runner) readInputs() ([]string, error) {
+	var reader io.Reader
+	if len(r.options.Domain) > 0 {
+		reader = strings.NewReader(strings.Join(r.options.Domain, "\n"))
+	} else if r.options.Stdin {
+		reader = os.Stdin
+	} else {
+		return nil, nil
+	}
+
+	var domains []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		domain := preprocessDomain(scanner.Text())
+		domain = replacer.Replace(domain)
+
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, scanner.Err()
+}
+
+func newEventWriter(options *Options) (*eventWriter, error) {
+	events := &eventWriter{
+		json:   options.JSON,
+		output: options.Output,
+	}
+	if options.EventsWebhook != "" {
+		events.webhook = newWebhookClient(options.EventsWebhook, options)
+		events.webhookFormat = options.WebhookFormat
+	}
+
+	if options.EventsOutput != "" {
+		outputWriter := NewOutputWriter(true)
+		file, err := outputWriter.createFile(options.EventsOutput, true)
+		if err != nil {
+			return nil, err
+		}
+		events.file = file
+	}
+	return events, nil
+}
+
+// write sends a single event to every configured destination
+func (e *eventWriter) write(ctx context.Context, event monitorEvent) error {
+	data, err := jsoniter.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if e.json {
+		_, err = fmt.Fprintf(e.output, "%s\n", data)
+	} else {
+		_, err = fmt.Fprintln(e.output, formatEvent(event))
+	}
+	if err != nil {
+		return err
+	}
+
+	if e.file != nil {
+		if _, err := fmt.Fprintf(e.file, "%s\n", data); err != nil {
+			return err
+		}
+	}
+
+	if e.webhook != nil {
+		payload, err := eventPayload(e.webhookFormat, event, data)
+		if err != nil {
+			return err
+		}
+		return e.webhook.send(ctx, payload)
+	}
+	return nil
+}
+
+// eventPayload renders an event posted to the events webhook in the given
+// format, data being the event encoded as json
+func eventPayload(format string, event monitorEvent, data []byte) ([]byte, error) {
+	switch format {
+	case webhookFormatSlack:
+		return jsoniter.Marshal(map[string]string{
+			"text": "tenantfinder: " + formatEvent(event),
+		})
+	case webhookFormatTeams:
+		return jsoniter.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  "tenantfinder " + event.Type,
+			"text":     "tenantfinder: " + formatEvent(event),
+		})
+	default:
+		return data, nil
+	}
+}
+
+func (e *eventWriter) close() {
+	if e.file != nil {
+		e.file.Close()
+	}
+}
+
+// formatEvent renders an event as a single plain text line
+func formatEvent(event monitorEvent) string {
+	switch event.Type {
+	case eventAuthenticationChanged:
+		return fmt.Sprintf("[%s] %s %s -> %s", event.Type, event.Tenant, event.Previous, event.Current)
+	default:
+		return fmt.Sprintf("[%s] %s %s", event.Type, event.Tenant, event.Domain)
+	}
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+// eventSummary drops the time of the events, which changes on every cycle
+func eventSummary(events []monitorEvent) [][]string {
+	summary := make([][]string, 0, len(events))
+	for _, event := range events {
+		summary = append(summary, []string{event.Type, event.Input, event.Tenant, event.Domain, event.Previous, event.Current})
+	}
+	return summary
+}
+
+func TestMonitorStateApply(t *testing.T) {
+	state, err := loadMonitorState("")
+	if err != nil {
+		t.Fatalf("could not load state: %s", err)
+	}
+
+	managed := diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com", "old.contoso.com"})
+	managed.Authentication = "managed"
+	fabrikam := diffResult("fabrikam.com", statusOK, []string{"fabrikam.com", "fabrikam.onmicrosoft.com"})
+
+	// The first cycle only records the baseline
+	if events := state.apply([]*inputResult{managed, fabrikam}); len(events) != 0 {
+		t.Fatalf("baseline events = %v, want none", eventSummary(events))
+	}
+	if got := state.Inputs["contoso.com"]; got != "contoso.onmicrosoft.com" {
+		t.Errorf("tenant of contoso.com = %q, want contoso.onmicrosoft.com", got)
+	}
+
+	federated := diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com", "new.contoso.com"})
+	federated.Authentication = "federated"
+	outside := diffResult("fabrikam.com", statusNotInTenant, nil)
+	events := state.apply([]*inputResult{federated, outside})
+
+	want := [][]string{
+		{eventDomainAdded, "contoso.com", "contoso.onmicrosoft.com", "new.contoso.com", "", ""},
+		{eventDomainRemoved, "contoso.com", "contoso.onmicrosoft.com", "old.contoso.com", "", ""},
+		{eventAuthenticationChanged, "contoso.com", "contoso.onmicrosoft.com", "", "managed", "federated"},
+		{eventDomainRemoved, "fabrikam.com", "fabrikam.onmicrosoft.com", "fabrikam.com", "", ""},
+		{eventDomainRemoved, "fabrikam.com", "fabrikam.onmicrosoft.com", "fabrikam.onmicrosoft.com", "", ""},
+	}
+	if got := eventSummary(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if _, ok := state.Inputs["fabrikam.com"]; ok {
+		t.Errorf("fabrikam.com is still mapped to a tenant after leaving it")
+	}
+
+	// A failed enumeration keeps the last known state of its tenant
+	failed := diffResult("contoso.com", statusError, nil)
+	if events := state.apply([]*inputResult{failed}); len(events) != 0 {
+		t.Errorf("events after a failed enumeration = %v, want none", eventSummary(events))
+	}
+	if got := len(state.Tenants["contoso.onmicrosoft.com"].Hosts); got != 3 {
+		t.Errorf("domains of contoso.onmicrosoft.com after a failed enumeration = %d, want 3", got)
+	}
+}
@@ -0,0 +1,177 @@
+package runner
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/upmux/tenantfinder/pkg/source/idp"
+)
+
+// Node and edge kinds used in the OpenGraph export
+const (
+	ogSourceKind = "TFBase"
+
+	ogTenantKind = "TFTenant"
+	ogDomainKind = "TFDomain"
+	ogIssuerKind = "TFFederationIssuer"
+
+	ogHasDomainKind    = "TFHasDomain"
+	ogTrustsIssuerKind = "TFTrustsIssuer"
+)
+
+// openGraph accumulates tenants, domains and federation issuers across
+// all inputs and renders them in the BloodHound OpenGraph format.
+type openGraph struct {
+	mutex sync.Mutex
+	nodes map[string]*ogNode
+	edges map[string]*ogEdge
+}
+
+type ogDocument struct {
+	Metadata ogMetadata `json:"metadata"`
+	Graph    ogGraph    `json:"graph"`
+}
+
+type ogMetadata struct {
+	SourceKind string `json:"source_kind"`
+}
+
+type ogGraph struct {
+	Nodes []*ogNode `json:"nodes"`
+	Edges []*ogEdge `json:"edges"`
+}
+
+type ogNode struct {
+	ID         string                 `json:"id"`
+	Kinds      []string               `json:"kinds"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type ogEdge struct {
+	Kind       string                 `json:"kind"`
+	Start      ogEndpoint             `json:"start"`
+	End        ogEndpoint             `json:"end"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type ogEndpoint struct {
+	Value   string `json:"value"`
+	MatchBy string `json:"match_by"`
+}
+
+func newOpenGraph() *openGraph {
+	return &openGraph{
+		nodes: make(map[string]*ogNode),
+		edges: make(map[string]*ogEdge),
+	}
+}
+
+// add records the tenant found for an input together with its domains
+// and federation issuers. Only the domains proving tenant membership are
+// hosts, the related domains of the mail records being findings.
+func (g *openGraph) add(result *inputResult) {
+	if len(result.Hosts) == 0 {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tenant := tenantName(result.Input, result.Hosts)
+	tenantID := g.addNode(ogTenantKind, tenant, map[string]interface{}{
+		"name": tenant,
+	})
+	appendProperty(g.nodes[tenantID], "inputs", result.Input)
+
+	for host, entry := range result.Hosts {
+		domainID := g.addNode(ogDomainKind, host, map[string]interface{}{
+			"name":   host,
+			"source": entry.Source,
+		})
+		g.addEdge(ogHasDomainKind, tenantID, domainID)
+	}
+
+	for uri, endpoint := range result.Issuers {
+		// Every tenant trusts Entra ID, only the third party issuers tell a trust path
+		if idp.IsMicrosoftIssuer(uri) {
+			continue
+		}
+		issuerID := g.addNode(ogIssuerKind, uri, map[string]interface{}{
+			"name":     uri,
+			"endpoint": endpoint,
+		})
+		g.addEdge(ogTrustsIssuerKind, tenantID, issuerID)
+	}
+}
+
+func (g *openGraph) addNode(kind, name string, properties map[string]interface{}) string {
+	id := strings.ToUpper(kind + "-" + name)
+	if _, ok := g.nodes[id]; !ok {
+		g.nodes[id] = &ogNode{
+			ID:         id,
+			Kinds:      []string{kind, ogSourceKind},
+			Properties: properties,
+		}
+	}
+	return id
+}
+
+func (g *openGraph) addEdge(kind, start, end string) {
+	key := kind + "|" + start + "|" + end
+	if _, ok := g.edges[key]; !ok {
+		g.edges[key] = &ogEdge{
+			Kind:  kind,
+			Start: ogEndpoint{Value: start, MatchBy: "id"},
+			End:   ogEndpoint{Value: end, MatchBy: "id"},
+		}
+	}
+}
+
+// appendProperty adds a value to a list property of the node if not already present
+func appendProperty(node *ogNode, key, value string) {
+	values, _ := node.Properties[key].([]string)
+	for _, v := range values {
+		if v == value {
+			return
+		}
+	}
+	node.Properties[key] = append(values, value)
+}
+
+// write renders the graph as a single OpenGraph json document
+func (g *openGraph) write(writer io.Writer) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	document := ogDocument{
+		Metadata: ogMetadata{SourceKind: ogSourceKind},
+		Graph: ogGraph{
+			Nodes: make([]*ogNode, 0, len(g.nodes)),
+			Edges: make([]*ogEdge, 0, len(g.edges)),
+		},
+	}
+	for _, node := range g.nodes {
+		document.Graph.Nodes = append(document.Graph.Nodes, node)
+	}
+	for _, edge := range g.edges {
+		document.Graph.Edges = append(document.Graph.Edges, edge)
+	}
+	sort.Slice(document.Graph.Nodes, func(i, j int) bool {
+		return document.Graph.Nodes[i].ID < document.Graph.Nodes[j].ID
+	})
+	sort.Slice(document.Graph.Edges, func(i, j int) bool {
+		a, b := document.Graph.Edges[i], document.Graph.Edges[j]
+		if a.Start.Value != b.Start.Value {
+			return a.Start.Value < b.Start.Value
+		}
+		return a.End.Value < b.End.Value
+	})
+
+	encoder := jsoniter.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&document)
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+func TestOpenGraph(t *testing.T) {
+	graph := newOpenGraph()
+
+	federated := diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com"},
+		source.Result{Source: "mail-correlation", Type: source.RelatedDomain, Value: "contoso-mail.com"},
+		source.Result{Source: "crtsh", Type: source.Hostname, Value: "vpn.contoso.com"})
+	federated.Issuers = map[string]string{
+		"urn:federation:MicrosoftOnline":              "https://login.microsoftonline.com/extSTS.srf",
+		"http://adfs.contoso.com/adfs/services/trust": "https://adfs.contoso.com/adfs/services/trust/2005/usernamemixed",
+	}
+	managed := diffResult("fabrikam.com", statusOK, []string{"fabrikam.com", "fabrikam.onmicrosoft.com"})
+	managed.Issuers = map[string]string{
+		"urn:federation:MicrosoftOnline": "https://login.microsoftonline.com/extSTS.srf",
+	}
+	// An input outside any tenant, even with related domains, adds nothing
+	outside := diffResult("example.org", statusNotInTenant, nil,
+		source.Result{Source: "mail-correlation", Type: source.RelatedDomain, Value: "example.net"})
+
+	for _, result := range []*inputResult{federated, managed, outside} {
+		graph.add(result)
+	}
+
+	var buffer bytes.Buffer
+	if err := graph.write(&buffer); err != nil {
+		t.Fatalf("could not write graph: %s", err)
+	}
+	var document ogDocument
+	if err := jsoniter.Unmarshal(buffer.Bytes(), &document); err != nil {
+		t.Fatalf("could not parse graph: %s", err)
+	}
+
+	var nodes []string
+	for _, node := range document.Graph.Nodes {
+		nodes = append(nodes, node.ID)
+	}
+	wantNodes := []string{
+		"TFDOMAIN-CONTOSO.COM",
+		"TFDOMAIN-CONTOSO.ONMICROSOFT.COM",
+		"TFDOMAIN-FABRIKAM.COM",
+		"TFDOMAIN-FABRIKAM.ONMICROSOFT.COM",
+		"TFFEDERATIONISSUER-HTTP://ADFS.CONTOSO.COM/ADFS/SERVICES/TRUST",
+		"TFTENANT-CONTOSO.ONMICROSOFT.COM",
+		"TFTENANT-FABRIKAM.ONMICROSOFT.COM",
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("nodes = %v, want %v", nodes, wantNodes)
+	}
+
+	var edges [][]string
+	for _, edge := range document.Graph.Edges {
+		edges = append(edges, []string{edge.Kind, edge.Start.Value, edge.End.Value})
+	}
+	wantEdges := [][]string{
+		{ogHasDomainKind, "TFTENANT-CONTOSO.ONMICROSOFT.COM", "TFDOMAIN-CONTOSO.COM"},
+		{ogHasDomainKind, "TFTENANT-CONTOSO.ONMICROSOFT.COM", "TFDOMAIN-CONTOSO.ONMICROSOFT.COM"},
+		{ogTrustsIssuerKind, "TFTENANT-CONTOSO.ONMICROSOFT.COM", "TFFEDERATIONISSUER-HTTP://ADFS.CONTOSO.COM/ADFS/SERVICES/TRUST"},
+		{ogHasDomainKind, "TFTENANT-FABRIKAM.ONMICROSOFT.COM", "TFDOMAIN-FABRIKAM.COM"},
+		{ogHasDomainKind, "TFTENANT-FABRIKAM.ONMICROSOFT.COM", "TFDOMAIN-FABRIKAM.ONMICROSOFT.COM"},
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("edges = %v, want %v", edges, wantEdges)
+	}
+}
@@ -2,38 +2,85 @@ package runner
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
 
 	jsoniter "github.com/json-iterator/go"
 
+	"github.com/upmux/tenantfinder/pkg/emailsec"
 	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/source"
 )
 
 // OutputWriter outputs content to writers.
 type OutputWriter struct {
-	JSON bool
+	JSON     bool
+	Template *template.Template
+	// StatusLines writes a line with the status of the inputs without any result
+	StatusLines bool
+	// PlainFindings writes the findings after the domains in plain output,
+	// which otherwise only holds domains so it can be piped to other tools
+	PlainFindings bool
 }
 
 type jsonSourceResult struct {
+	Domain    string `json:"domain"`
+	Input     string `json:"input"`
+	Source    string `json:"source"`
+	Reference string `json:"reference,omitempty"`
+	Status    string `json:"status,omitempty"`
+	// Email is the email security posture of the domain when it was checked
+	Email *emailsec.Posture `json:"email,omitempty"`
+}
+
+type jsonFindingResult struct {
+	Input     string `json:"input"`
+	Source    string `json:"source"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Reference string `json:"reference,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+type jsonSourceIPResult struct {
 	Domain string `json:"domain"`
 	Input  string `json:"input"`
 	Source string `json:"source"`
 }
 
-type jsonSourceIPResult struct {
+type jsonChangeResult struct {
 	Domain string `json:"domain"`
 	Input  string `json:"input"`
+	Tenant string `json:"tenant"`
 	Source string `json:"source"`
+	Change string `json:"change"`
+}
+
+type jsonFindingChangeResult struct {
+	Input  string `json:"input"`
+	Tenant string `json:"tenant"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	Change string `json:"change"`
+}
+
+type jsonStatusResult struct {
+	Input  string `json:"input"`
+	Status string `json:"status"`
 }
 
 type jsonSourcesResult struct {
-	Domain  string   `json:"domain"`
-	Input   string   `json:"input"`
-	Sources []string `json:"sources"`
+	Domain  string            `json:"domain"`
+	Input   string            `json:"input"`
+	Sources []string          `json:"sources"`
+	Status  string            `json:"status,omitempty"`
+	Email   *emailsec.Posture `json:"email,omitempty"`
 }
 
 // NewOutputWriter creates a new OutputWriter
@@ -123,17 +170,17 @@ func writeJSONHostIP(input string, results map[string]resolve.Result, writer io.
 }
 
 // WriteHost writes the output list of domain to an io.Writer
-func (o *OutputWriter) WriteHost(input string, results map[string]resolve.HostEntry, writer io.Writer) error {
+func (o *OutputWriter) WriteHost(result *inputResult, writer io.Writer) error {
 	var err error
 	if o.JSON {
-		err = writeJSONHost(input, results, writer)
+		err = writeJSONHost(result, o.StatusLines, writer)
 	} else {
-		err = writePlainHost(input, results, writer)
+		err = writePlainHost(result.Input, result.Hosts, o.plainFindings(result), writer)
 	}
 	return err
 }
 
-func writePlainHost(_ string, results map[string]resolve.HostEntry, writer io.Writer) error {
+func writePlainHost(_ string, results map[string]resolve.HostEntry, findings []source.Result, writer io.Writer) error {
 	bufwriter := bufio.NewWriter(writer)
 	sb := &strings.Builder{}
 
@@ -148,44 +195,117 @@ func writePlainHost(_ string, results map[string]resolve.HostEntry, writer io.Wr
 		}
 		sb.Reset()
 	}
+	if err := writePlainFindings(findings, bufwriter); err != nil {
+		bufwriter.Flush()
+		return err
+	}
 	return bufwriter.Flush()
 }
 
-func writeJSONHost(input string, results map[string]resolve.HostEntry, writer io.Writer) error {
+// plainFindings returns the findings of the input written in plain output
+func (o *OutputWriter) plainFindings(result *inputResult) []source.Result {
+	if !o.PlainFindings {
+		return nil
+	}
+	return result.Findings
+}
+
+// writePlainFindings writes a tab separated type, value and reference line per finding
+func writePlainFindings(findings []source.Result, bufwriter *bufio.Writer) error {
+	sb := &strings.Builder{}
+
+	for _, finding := range findings {
+		sb.WriteString(finding.Type.String())
+		sb.WriteString("\t")
+		sb.WriteString(finding.Value)
+		sb.WriteString("\t")
+		sb.WriteString(finding.Reference)
+		sb.WriteString("\n")
+
+		if _, err := bufwriter.WriteString(sb.String()); err != nil {
+			return err
+		}
+		sb.Reset()
+	}
+	return nil
+}
+
+// writeJSONHost writes a line per domain and finding, or a single line
+// with the status of the input when nothing was found and statusLines is set
+func writeJSONHost(result *inputResult, statusLines bool, writer io.Writer) error {
 	encoder := jsoniter.NewEncoder(writer)
 
+	if len(result.Hosts) == 0 && len(result.Findings) == 0 {
+		return writeJSONStatus(encoder, result, statusLines)
+	}
+
 	var data jsonSourceResult
-	for _, result := range results {
-		data.Domain = result.Host
-		data.Input = input
-		data.Source = result.Source
+	for _, host := range result.Hosts {
+		data.Domain = host.Host
+		data.Input = result.Input
+		data.Source = host.Source
+		data.Reference = host.Reference
+		data.Status = result.Status
+		data.Email = result.Email[host.Host]
 		err := encoder.Encode(data)
 		if err != nil {
 			return err
 		}
 	}
+	return writeJSONFindings(encoder, result.Input, result.Status, result.Findings)
+}
+
+// writeJSONStatus writes the status line of an input without any result when statusLines is set
+func writeJSONStatus(encoder *jsoniter.Encoder, result *inputResult, statusLines bool) error {
+	if !statusLines {
+		return nil
+	}
+	return encoder.Encode(jsonStatusResult{Input: result.Input, Status: result.Status})
+}
+
+// writeJSONFindings writes a line per finding of an input
+func writeJSONFindings(encoder *jsoniter.Encoder, input, status string, findings []source.Result) error {
+	for _, finding := range findings {
+		err := encoder.Encode(jsonFindingResult{
+			Input:     input,
+			Source:    finding.Source,
+			Type:      finding.Type.String(),
+			Value:     finding.Value,
+			Reference: finding.Reference,
+			Status:    status,
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // WriteSourceHost writes the output list of domain to an io.Writer
-func (o *OutputWriter) WriteSourceHost(input string, sourceMap map[string]map[string]struct{}, writer io.Writer) error {
+func (o *OutputWriter) WriteSourceHost(result *inputResult, writer io.Writer) error {
 	var err error
 	if o.JSON {
-		err = writeSourceJSONHost(input, sourceMap, writer)
+		err = writeSourceJSONHost(result, o.StatusLines, writer)
 	} else {
-		err = writeSourcePlainHost(input, sourceMap, writer)
+		err = writeSourcePlainHost(result.Input, result.Sources, o.plainFindings(result), writer)
 	}
 	return err
 }
 
-func writeSourceJSONHost(input string, sourceMap map[string]map[string]struct{}, writer io.Writer) error {
+func writeSourceJSONHost(result *inputResult, statusLines bool, writer io.Writer) error {
 	encoder := jsoniter.NewEncoder(writer)
 
+	if len(result.Sources) == 0 && len(result.Findings) == 0 {
+		return writeJSONStatus(encoder, result, statusLines)
+	}
+
 	var data jsonSourcesResult
 
-	for host, sources := range sourceMap {
+	for host, sources := range result.Sources {
 		data.Domain = host
-		data.Input = input
+		data.Input = result.Input
+		data.Status = result.Status
+		data.Email = result.Email[host]
 		keys := make([]string, 0, len(sources))
 		for source := range sources {
 			keys = append(keys, source)
@@ -197,10 +317,10 @@ func writeSourceJSONHost(input string, sourceMap map[string]map[string]struct{},
 			return err
 		}
 	}
-	return nil
+	return writeJSONFindings(encoder, result.Input, result.Status, result.Findings)
 }
 
-func writeSourcePlainHost(_ string, sourceMap map[string]map[string]struct{}, writer io.Writer) error {
+func writeSourcePlainHost(_ string, sourceMap map[string]map[string]struct{}, findings []source.Result, writer io.Writer) error {
 	bufwriter := bufio.NewWriter(writer)
 	sb := &strings.Builder{}
 
@@ -221,5 +341,89 @@ func writeSourcePlainHost(_ string, sourceMap map[string]map[string]struct{}, wr
 		}
 		sb.Reset()
 	}
+	if err := writePlainFindings(findings, bufwriter); err != nil {
+		bufwriter.Flush()
+		return err
+	}
+	return bufwriter.Flush()
+}
+
+// WriteTemplateHost writes every domain and finding of an input formatted with
+// the output template, leaving out the executions rendering a blank line
+func (o *OutputWriter) WriteTemplateHost(result *inputResult, writer io.Writer) error {
+	bufwriter := bufio.NewWriter(writer)
+	line := &bytes.Buffer{}
+
+	for _, data := range templateResults(result) {
+		line.Reset()
+		if err := o.Template.Execute(line, data); err != nil {
+			bufwriter.Flush()
+			return err
+		}
+		if len(bytes.TrimSpace(line.Bytes())) == 0 {
+			continue
+		}
+		if _, err := bufwriter.Write(line.Bytes()); err != nil {
+			bufwriter.Flush()
+			return err
+		}
+	}
 	return bufwriter.Flush()
 }
+
+// WriteChangedHost writes the domains and findings added or removed since the previous run to an io.Writer
+func (o *OutputWriter) WriteChangedHost(input string, changes []resultChange, writer io.Writer) error {
+	var err error
+	if o.JSON {
+		err = writeJSONChangedHost(input, changes, writer)
+	} else {
+		err = writePlainChangedHost(input, changes, writer)
+	}
+	return err
+}
+
+// writePlainChangedHost writes a domain,change line per domain and a tab
+// separated type, value and change line per finding
+func writePlainChangedHost(_ string, changes []resultChange, writer io.Writer) error {
+	bufwriter := bufio.NewWriter(writer)
+	sb := &strings.Builder{}
+
+	for _, change := range changes {
+		if change.isDomain() {
+			sb.WriteString(change.Value)
+			sb.WriteString(",")
+		} else {
+			sb.WriteString(change.Type)
+			sb.WriteString("\t")
+			sb.WriteString(change.Value)
+			sb.WriteString("\t")
+		}
+		sb.WriteString(change.Change)
+		sb.WriteString("\n")
+
+		_, err := bufwriter.WriteString(sb.String())
+		if err != nil {
+			bufwriter.Flush()
+			return err
+		}
+		sb.Reset()
+	}
+	return bufwriter.Flush()
+}
+
+func writeJSONChangedHost(input string, changes []resultChange, writer io.Writer) error {
+	encoder := jsoniter.NewEncoder(writer)
+
+	for _, change := range changes {
+		var data interface{}
+		if change.isDomain() {
+			data = jsonChangeResult{Domain: change.Value, Input: input, Tenant: change.Tenant, Source: change.Source, Change: change.Change}
+		} else {
+			data = jsonFindingChangeResult{Input: input, Tenant: change.Tenant, Source: change.Source, Type: change.Type, Value: change.Value, Change: change.Change}
+		}
+		if err := encoder.Encode(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+func TestWriteJSONHostStatusLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		result      *inputResult
+		statusLines bool
+		want        string
+	}{
+		{
+			name:   "domains",
+			result: &inputResult{Input: "contoso.com", Status: statusOK, Hosts: map[string]resolve.HostEntry{"contoso.com": {Host: "contoso.com", Source: "aad"}}},
+			want:   `{"domain":"contoso.com","input":"contoso.com","source":"aad","status":"ok"}` + "\n",
+		},
+		{
+			name:   "no result without status lines",
+			result: &inputResult{Input: "example.org", Status: statusNotInTenant},
+		},
+		{
+			name:        "no result with status lines",
+			result:      &inputResult{Input: "example.org", Status: statusNotInTenant},
+			statusLines: true,
+			want:        `{"input":"example.org","status":"not_in_tenant"}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := &bytes.Buffer{}
+			if err := writeJSONHost(test.result, test.statusLines, writer); err != nil {
+				t.Fatalf("could not write result: %s", err)
+			}
+			if got := writer.String(); got != test.want {
+				t.Errorf("output = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWritePlainHostFindings(t *testing.T) {
+	result := diffResult("contoso.com", statusOK, []string{"contoso.com"},
+		source.Result{Source: "autodiscover", Type: source.Endpoint, Value: "https://outlook.office365.com/EWS/Exchange.asmx", Reference: "EWS"})
+
+	tests := []struct {
+		name          string
+		plainFindings bool
+		want          string
+	}{
+		{name: "domains only", want: "contoso.com\n"},
+		{name: "with findings", plainFindings: true, want: "contoso.com\nendpoint\thttps://outlook.office365.com/EWS/Exchange.asmx\tEWS\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputWriter := NewOutputWriter(false)
+			outputWriter.PlainFindings = test.plainFindings
+			writer := &bytes.Buffer{}
+			if err := outputWriter.WriteHost(result, writer); err != nil {
+				t.Fatalf("could not write result: %s", err)
+			}
+			if got := writer.String(); got != test.want {
+				t.Errorf("output = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,569 @@
+package runner
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/upmux/tenantfinder/pkg/emailsec"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// Supported report formats, selected by the extension of the report file
+const (
+	reportFormatHTML     = "html"
+	reportFormatMarkdown = "md"
+)
+
+// Domain classifications used in the report
+const (
+	classInitial   = "initial"
+	classApex      = "apex"
+	classSubdomain = "subdomain"
+)
+
+// report accumulates the results of all inputs and renders them
+// as a self-contained html or markdown document once the run is over.
+type report struct {
+	mutex     sync.Mutex
+	startTime time.Time
+	results   []*inputResult
+}
+
+type reportData struct {
+	Version    string
+	Generated  string
+	Duration   string
+	Parameters []reportParameter
+	Tenants    []reportTenant
+	Inputs     []reportInput
+	Findings   []reportFinding
+	Statistics []reportStatistic
+	Errors     []reportError
+}
+
+type reportParameter struct {
+	Name  string
+	Value string
+}
+
+type reportInput struct {
+	Input   string
+	Status  string
+	Domains int
+}
+
+type reportFinding struct {
+	Input     string
+	Type      string
+	Value     string
+	Reference string
+	Source    string
+}
+
+type reportTenant struct {
+	Name        string
+	Inputs      []string
+	Issuers     []string
+	DomainCount int
+	Groups      []reportDomainGroup
+	Email       []reportEmail
+}
+
+// reportEmail summarizes the email security posture of a domain
+type reportEmail struct {
+	Domain    string
+	SPF       string
+	DMARC     string
+	MTASTS    string
+	TLSRPT    string
+	DKIM      string
+	Spoofable bool
+}
+
+type reportDomainGroup struct {
+	RegistrableDomain string
+	Domains           []reportDomain
+}
+
+type reportDomain struct {
+	Name           string
+	Classification string
+	Sources        string
+}
+
+type reportStatistic struct {
+	Source    string
+	TimeTaken time.Duration
+	Results   int
+	Errors    int
+	Throttled int
+	Skipped   bool
+}
+
+type reportError struct {
+	Input  string
+	Errors []string
+}
+
+func newReport() *report {
+	return &report{startTime: time.Now()}
+}
+
+// add records the result of a single input
+func (rp *report) add(result *inputResult) {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	rp.results = append(rp.results, result)
+}
+
+// reportFormat returns the report format for the given file name
+func reportFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".html", ".htm":
+		return reportFormatHTML
+	case ".md", ".markdown":
+		return reportFormatMarkdown
+	}
+	return ""
+}
+
+// write renders the report in the given format
+func (rp *report) write(writer io.Writer, format string, options *Options) error {
+	rp.mutex.Lock()
+	data := rp.build(options)
+	rp.mutex.Unlock()
+
+	if format == reportFormatHTML {
+		tmpl, err := htmltemplate.New("report").Parse(htmlReportTemplate)
+		if err != nil {
+			return err
+		}
+		return tmpl.Execute(writer, data)
+	}
+
+	tmpl, err := texttemplate.New("report").Funcs(texttemplate.FuncMap{
+		"cell": markdownCell,
+		"text": markdownText,
+	}).Parse(markdownReportTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(writer, data)
+}
+
+func (rp *report) build(options *Options) *reportData {
+	data := &reportData{
+		Version:    version,
+		Generated:  time.Now().Format(time.RFC1123),
+		Duration:   time.Since(rp.startTime).Round(time.Millisecond).String(),
+		Parameters: reportParameters(options, len(rp.results)),
+	}
+
+	tenants := make(map[string]*tenantAggregate)
+	var tenantNames []string
+	statistics := make(map[string]*reportStatistic)
+
+	for _, result := range rp.results {
+		data.Inputs = append(data.Inputs, reportInput{Input: result.Input, Status: result.Status, Domains: len(result.Hosts)})
+		for _, finding := range result.Findings {
+			data.Findings = append(data.Findings, reportFinding{
+				Input:     result.Input,
+				Type:      finding.Type.String(),
+				Value:     finding.Value,
+				Reference: finding.Reference,
+				Source:    finding.Source,
+			})
+		}
+		if len(result.Errors) > 0 {
+			data.Errors = append(data.Errors, reportError{Input: result.Input, Errors: result.Errors})
+		}
+
+		for name, stat := range result.Statistics {
+			aggregate, ok := statistics[name]
+			if !ok {
+				aggregate = &reportStatistic{Source: name, Skipped: true}
+				statistics[name] = aggregate
+			}
+			addStatistics(aggregate, stat)
+		}
+
+		if len(result.Hosts) == 0 {
+			continue
+		}
+
+		// Inputs belonging to the same tenant are merged into a single entry
+		name := tenantName(result.Input, result.Hosts)
+		tenant, ok := tenants[name]
+		if !ok {
+			tenant = &tenantAggregate{
+				Sources: make(map[string]map[string]struct{}),
+				Issuers: make(map[string]string),
+				Email:   make(map[string]*emailsec.Posture),
+			}
+			tenants[name] = tenant
+			tenantNames = append(tenantNames, name)
+		}
+		tenant.Inputs = append(tenant.Inputs, result.Input)
+		for host, sources := range result.Sources {
+			if _, ok := tenant.Sources[host]; !ok {
+				tenant.Sources[host] = make(map[string]struct{})
+			}
+			for source := range sources {
+				tenant.Sources[host][source] = struct{}{}
+			}
+		}
+		for uri, endpoint := range result.Issuers {
+			tenant.Issuers[uri] = endpoint
+		}
+		maps.Copy(tenant.Email, result.Email)
+	}
+
+	sort.Strings(tenantNames)
+	for _, name := range tenantNames {
+		data.Tenants = append(data.Tenants, buildReportTenant(name, tenants[name]))
+	}
+
+	names := make([]string, 0, len(statistics))
+	for name := range statistics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data.Statistics = append(data.Statistics, *statistics[name])
+	}
+
+	return data
+}
+
+// tenantAggregate merges the results of all inputs belonging to one tenant
+type tenantAggregate struct {
+	Inputs  []string
+	Sources map[string]map[string]struct{}
+	Issuers map[string]string
+	Email   map[string]*emailsec.Posture
+}
+
+func buildReportTenant(name string, merged *tenantAggregate) reportTenant {
+	tenant := reportTenant{
+		Name:        name,
+		Inputs:      merged.Inputs,
+		DomainCount: len(merged.Sources),
+	}
+	for uri := range merged.Issuers {
+		tenant.Issuers = append(tenant.Issuers, uri)
+	}
+	sort.Strings(tenant.Issuers)
+
+	groups := make(map[string][]reportDomain)
+	for host, sources := range merged.Sources {
+		registrable := registrableDomain(host)
+		sourceNames := make([]string, 0, len(sources))
+		for source := range sources {
+			sourceNames = append(sourceNames, source)
+		}
+		sort.Strings(sourceNames)
+
+		groups[registrable] = append(groups[registrable], reportDomain{
+			Name:           host,
+			Classification: classifyDomain(host, registrable),
+			Sources:        strings.Join(sourceNames, ", "),
+		})
+	}
+
+	for registrable, domains := range groups {
+		sort.Slice(domains, func(i, j int) bool {
+			if domains[i].Classification != domains[j].Classification {
+				return domains[i].Classification < domains[j].Classification
+			}
+			return domains[i].Name < domains[j].Name
+		})
+		tenant.Groups = append(tenant.Groups, reportDomainGroup{RegistrableDomain: registrable, Domains: domains})
+	}
+	sort.Slice(tenant.Groups, func(i, j int) bool {
+		return tenant.Groups[i].RegistrableDomain < tenant.Groups[j].RegistrableDomain
+	})
+
+	for domain, posture := range merged.Email {
+		tenant.Email = append(tenant.Email, summarizeEmail(domain, posture))
+	}
+	sort.Slice(tenant.Email, func(i, j int) bool {
+		return tenant.Email[i].Domain < tenant.Email[j].Domain
+	})
+	return tenant
+}
+
+func addStatistics(aggregate *reportStatistic, stat source.Statistics) {
+	aggregate.TimeTaken += stat.TimeTaken.Round(time.Millisecond)
+	aggregate.Results += stat.Results
+	aggregate.Errors += stat.Errors
+	aggregate.Throttled += stat.Throttled
+	aggregate.Skipped = aggregate.Skipped && stat.Skipped
+}
+
+func reportParameters(options *Options, inputs int) []reportParameter {
+	sources := "default"
+	if options.All {
+		sources = "all"
+	} else if len(options.Sources) > 0 {
+		sources = strings.Join(options.Sources, ", ")
+	}
+
+	var rateLimits []string
+	for source, rateLimit := range options.RateLimits.AsMap() {
+		rateLimits = append(rateLimits, fmt.Sprintf("%s=%d/%s", source, rateLimit.MaxCount, rateLimit.Duration))
+	}
+	sort.Strings(rateLimits)
+
+	return []reportParameter{
+		{Name: "Inputs", Value: strconv.Itoa(inputs)},
+		{Name: "Sources", Value: sources},
+		{Name: "Excluded sources", Value: strings.Join(options.ExcludeSources, ", ")},
+		{Name: "Rate limit", Value: strconv.Itoa(options.RateLimit)},
+		{Name: "Source rate limits", Value: strings.Join(rateLimits, ", ")},
+		{Name: "Timeout", Value: fmt.Sprintf("%ds", options.Timeout)},
+		{Name: "Max enumeration time", Value: fmt.Sprintf("%dm", options.MaxEnumerationTime)},
+	}
+}
+
+// registrableDomain returns the registrable (eTLD+1) domain of a host
+func registrableDomain(host string) string {
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return registrable
+}
+
+// classifyDomain classifies a host as the tenant's initial domain,
+// a registrable domain or a subdomain
+func classifyDomain(host, registrable string) string {
+	switch {
+	// The mail routing domain of the tenant is not its initial domain
+	case strings.HasSuffix(host, ".onmicrosoft.com") && !strings.HasSuffix(host, ".mail.onmicrosoft.com"):
+		return classInitial
+	case host == registrable:
+		return classApex
+	default:
+		return classSubdomain
+	}
+}
+
+// markdownCell escapes a value for use in a markdown table cell
+func markdownCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
+
+// markdownEscaper escapes the characters markdown would render as formatting
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"`", "\\`",
+	"*", "\\*",
+	"_", "\\_",
+	"[", "\\[",
+	"]", "\\]",
+	"<", "\\<",
+	">", "\\>",
+	"#", "\\#",
+	"|", "\\|",
+	"\n", " ",
+)
+
+// markdownText escapes a value for use in markdown text, such as a heading or a list item
+func markdownText(value string) string {
+	return markdownEscaper.Replace(value)
+}
+
+const markdownReportTemplate = `# tenantfinder report
+
+Generated {{.Generated}} by tenantfinder {{.Version}} in {{.Duration}}.
+
+## Run parameters
+
+| Parameter | Value |
+|-----------|-------|
+{{- range .Parameters}}
+| {{cell .Name}} | {{cell .Value}} |
+{{- end}}
+
+## Tenants
+{{if not .Tenants}}
+No tenants found.
+{{end}}
+{{- range .Tenants}}
+### {{text .Name}}
+
+- Inputs: {{range $i, $input := .Inputs}}{{if $i}}, {{end}}{{text $input}}{{end}}
+- Domains: {{.DomainCount}}
+{{- if .Issuers}}
+- Federation issuers: {{range $i, $issuer := .Issuers}}{{if $i}}, {{end}}{{text $issuer}}{{end}}
+{{- end}}
+{{range .Groups}}
+#### {{text .RegistrableDomain}}
+
+| Domain | Classification | Sources |
+|--------|----------------|---------|
+{{- range .Domains}}
+| {{cell .Name}} | {{cell .Classification}} | {{cell .Sources}} |
+{{- end}}
+{{end}}
+{{- if .Email}}
+#### Email security
+
+| Domain | SPF | DMARC | MTA-STS | TLS-RPT | DKIM | Spoofable |
+|--------|-----|-------|---------|---------|------|-----------|
+{{- range .Email}}
+| {{cell .Domain}} | {{cell .SPF}} | {{cell .DMARC}} | {{cell .MTASTS}} | {{cell .TLSRPT}} | {{cell .DKIM}} | {{if .Spoofable}}**yes**{{else}}no{{end}} |
+{{- end}}
+{{end}}
+{{- end}}
+{{if .Findings -}}
+## Findings
+
+| Input | Type | Value | Reference | Source |
+|-------|------|-------|-----------|--------|
+{{- range .Findings}}
+| {{cell .Input}} | {{cell .Type}} | {{cell .Value}} | {{cell .Reference}} | {{cell .Source}} |
+{{- end}}
+
+{{end -}}
+## Inputs
+
+| Input | Status | Domains |
+|-------|--------|---------|
+{{- range .Inputs}}
+| {{cell .Input}} | {{cell .Status}} | {{.Domains}} |
+{{- end}}
+
+## Source statistics
+
+| Source | Duration | Results | Errors | Throttled |
+|--------|----------|---------|--------|-----------|
+{{- range .Statistics}}
+| {{cell .Source}} | {{if .Skipped}}skipped{{else}}{{.TimeTaken}}{{end}} | {{.Results}} | {{.Errors}} | {{.Throttled}} |
+{{- end}}
+
+## Errors
+{{if not .Errors}}
+No errors encountered.
+{{end}}
+{{- range .Errors}}
+### {{text .Input}}
+{{range .Errors}}
+- {{text .}}
+{{- end}}
+{{end}}`
+
+const htmlReportTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>tenantfinder report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1100px; color: #222; }
+h1, h2 { border-bottom: 1px solid #ddd; padding-bottom: .3em; }
+table { border-collapse: collapse; margin: 1em 0; width: 100%; }
+th, td { border: 1px solid #ddd; padding: .4em .8em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.muted { color: #777; }
+.tag { background: #eef; border-radius: 3px; font-size: .85em; padding: .1em .4em; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<h1>tenantfinder report</h1>
+<p class="muted">Generated {{.Generated}} by tenantfinder {{.Version}} in {{.Duration}}.</p>
+
+<h2>Run parameters</h2>
+<table>
+<tr><th>Parameter</th><th>Value</th></tr>
+{{- range .Parameters}}
+<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Tenants</h2>
+{{- if not .Tenants}}
+<p class="muted">No tenants found.</p>
+{{- end}}
+{{- range .Tenants}}
+<h3>{{.Name}}</h3>
+<p>Inputs: {{range $i, $input := .Inputs}}{{if $i}}, {{end}}{{$input}}{{end}}<br>
+Domains: {{.DomainCount}}
+{{- if .Issuers}}<br>
+Federation issuers: {{range $i, $issuer := .Issuers}}{{if $i}}, {{end}}{{$issuer}}{{end}}
+{{- end}}</p>
+{{- range .Groups}}
+<h4>{{.RegistrableDomain}}</h4>
+<table>
+<tr><th>Domain</th><th>Classification</th><th>Sources</th></tr>
+{{- range .Domains}}
+<tr><td>{{.Name}}</td><td><span class="tag">{{.Classification}}</span></td><td>{{.Sources}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Email}}
+<h4>Email security</h4>
+<table>
+<tr><th>Domain</th><th>SPF</th><th>DMARC</th><th>MTA-STS</th><th>TLS-RPT</th><th>DKIM</th><th>Spoofable</th></tr>
+{{- range .Email}}
+<tr><td>{{.Domain}}</td><td>{{.SPF}}</td><td>{{.DMARC}}</td><td>{{.MTASTS}}</td><td>{{.TLSRPT}}</td><td>{{.DKIM}}</td><td>{{if .Spoofable}}<span class="error">yes</span>{{else}}no{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+
+{{- if .Findings}}
+
+<h2>Findings</h2>
+<table>
+<tr><th>Input</th><th>Type</th><th>Value</th><th>Reference</th><th>Source</th></tr>
+{{- range .Findings}}
+<tr><td>{{.Input}}</td><td><span class="tag">{{.Type}}</span></td><td>{{.Value}}</td><td>{{.Reference}}</td><td>{{.Source}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>Inputs</h2>
+<table>
+<tr><th>Input</th><th>Status</th><th>Domains</th></tr>
+{{- range .Inputs}}
+<tr><td>{{.Input}}</td><td><span class="tag">{{.Status}}</span></td><td>{{.Domains}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Source statistics</h2>
+<table>
+<tr><th>Source</th><th>Duration</th><th>Results</th><th>Errors</th><th>Throttled</th></tr>
+{{- range .Statistics}}
+<tr><td>{{.Source}}</td><td>{{if .Skipped}}skipped{{else}}{{.TimeTaken}}{{end}}</td><td>{{.Results}}</td><td>{{.Errors}}</td><td>{{.Throttled}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Errors</h2>
+{{- if not .Errors}}
+<p class="muted">No errors encountered.</p>
+{{- end}}
+{{- range .Errors}}
+<h3>{{.Input}}</h3>
+<ul>
+{{- range .Errors}}
+<li class="error">{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`
@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+func TestMarkdownCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "contoso.com", want: "contoso.com"},
+		{value: "v=spf1 a|mx -all", want: `v=spf1 a\|mx -all`},
+		{value: "line\nbreak", want: "line break"},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if got := markdownCell(test.value); got != test.want {
+				t.Errorf("cell = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownText(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "contoso.onmicrosoft.com", want: "contoso.onmicrosoft.com"},
+		{value: "_dmarc.contoso.com", want: `\_dmarc.contoso.com`},
+		{value: "http://sts.contoso.com/adfs/services/trust#*", want: `http://sts.contoso.com/adfs/services/trust\#\*`},
+		{value: "aad: [<b>`x`</b>]", want: "aad: \\[\\<b\\>\\`x\\`\\</b\\>\\]"},
+		{value: `a\b|c` + "\n" + "d", want: `a\\b\|c d`},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if got := markdownText(test.value); got != test.want {
+				t.Errorf("text = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClassifyDomain(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "contoso.onmicrosoft.com", want: classInitial},
+		{host: "contoso.mail.onmicrosoft.com", want: classSubdomain},
+		{host: "contoso.com", want: classApex},
+		{host: "mail.contoso.com", want: classSubdomain},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if got := classifyDomain(test.host, registrableDomain(test.host)); got != test.want {
+				t.Errorf("classifyDomain() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestMarkdownReportCells checks values with table separators stay in their cell
+func TestMarkdownReportCells(t *testing.T) {
+	rp := newReport()
+	rp.add(&inputResult{
+		Input:  "contoso.com|x",
+		Status: statusOK,
+		Hosts: map[string]resolve.HostEntry{
+			"contoso.onmicrosoft.com": {Host: "contoso.onmicrosoft.com", Source: "aad"},
+			"contoso.com|x":           {Host: "contoso.com|x", Source: "aad"},
+		},
+		Findings: []source.Result{{Source: "autodiscover", Type: source.Endpoint, Value: "https://mail.contoso.com/EWS", Reference: "EWS|legacy"}},
+	})
+
+	writer := &bytes.Buffer{}
+	if err := rp.write(writer, reportFormatMarkdown, &Options{}); err != nil {
+		t.Fatalf("could not write report: %s", err)
+	}
+	for _, line := range strings.Split(writer.String(), "\n") {
+		if !strings.HasPrefix(line, "| ") {
+			continue
+		}
+		if strings.Contains(line, "|x") && !strings.Contains(line, `\|x`) {
+			t.Errorf("unescaped separator in row %q", line)
+		}
+		if strings.Contains(line, "EWS|legacy") {
+			t.Errorf("unescaped separator in row %q", line)
+		}
+	}
+}
+
+// TestMarkdownReportText checks values outside of the tables are not rendered as markdown
+func TestMarkdownReportText(t *testing.T) {
+	rp := newReport()
+	rp.add(&inputResult{
+		Input:   "*contoso*.com",
+		Status:  statusOK,
+		Hosts:   map[string]resolve.HostEntry{"contoso.onmicrosoft.com": {Host: "contoso.onmicrosoft.com", Source: "aad"}},
+		Issuers: map[string]string{"http://sts.contoso.com/adfs/services/trust#[x]": ""},
+		Errors:  []string{"aad: unexpected <b>response</b>"},
+	})
+
+	writer := &bytes.Buffer{}
+	if err := rp.write(writer, reportFormatMarkdown, &Options{}); err != nil {
+		t.Fatalf("could not write report: %s", err)
+	}
+	for _, want := range []string{
+		`- Inputs: \*contoso\*.com`,
+		`- Federation issuers: http://sts.contoso.com/adfs/services/trust\#\[x\]`,
+		`### \*contoso\*.com`,
+		`- aad: unexpected \<b\>response\</b\>`,
+	} {
+		if !strings.Contains(writer.String(), want+"\n") {
+			t.Errorf("report does not contain %q:\n%s", want, writer.String())
+		}
+	}
+}
@@ -3,16 +3,25 @@ package runner
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"io"
 	"math"
+	"net/http"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/upmux/tenantfinder/pkg/agent"
+	"github.com/upmux/tenantfinder/pkg/emailsec"
+	"github.com/upmux/tenantfinder/pkg/metrics"
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/session"
 
 	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/ratelimit"
 	contextutil "github.com/projectdiscovery/utils/context"
 	mapsutil "github.com/projectdiscovery/utils/maps"
 )
@@ -23,8 +32,25 @@ type Runner struct {
 	options   *Options
 	agent     *agent.Agent
 	rateLimit *agent.CustomRateLimit
-	// TODO Add DNS resolution
-	// resolverClient *resolve.Resolver
+	// multiRateLimiter is shared by all enumerations when set,
+	// otherwise every enumeration builds its own
+	multiRateLimiter *ratelimit.MultiLimiter
+	graph            *openGraph
+	report           *report
+	template         *template.Template
+	store            *sqliteStore
+	differ           *differ
+	webhook          *webhookSink
+	// failed receives the inputs whose enumeration failed
+	failed    *os.File
+	proxyPool *session.ProxyPool
+	tlsConfig *tls.Config
+	har       *session.HARRecorder
+	replay    *session.HARReplay
+	// emailChecker checks the email security posture of the found domains when set
+	emailChecker *emailsec.Checker
+	// ipResolver resolves the found domains when the output template uses their addresses
+	ipResolver *resolve.Resolver
 }
 
 // NewRunner creates a new runner struct instance by parsing
@@ -47,12 +73,57 @@ func NewRunner(options *Options) (*Runner, error) {
 	// Initialize the passive subdomain enumeration engine
 	runner.initializeAgent()
 
+	if options.MetricsListen != "" {
+		runner.startMetricsServer()
+	}
+
 	// // Initialize the subdomain resolver
 	// err := runner.initializeResolver()
 	// if err != nil {
 	// 	return nil, err
 	// // }
 
+	tlsConfig, err := session.NewTLSConfig(session.TLSOptions{
+		Insecure:   options.Insecure,
+		CACert:     options.CACert,
+		ClientCert: options.ClientCert,
+		ClientKey:  options.ClientKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	runner.tlsConfig = tlsConfig
+	if options.Insecure {
+		gologger.Warning().Msgf("TLS certificate verification is disabled\n")
+	}
+
+	if options.HAR != "" {
+		runner.har = session.NewHARRecorder(version)
+	}
+	if options.Replay != "" {
+		replay, err := session.LoadHARReplay(options.Replay)
+		if err != nil {
+			return nil, err
+		}
+		runner.replay = replay
+	}
+
+	if options.Proxy != "" || options.ProxyList != "" {
+		proxyPool, err := newProxyPool(options)
+		if err != nil {
+			return nil, err
+		}
+		runner.proxyPool = proxyPool
+	}
+
+	if options.EmailSecurity {
+		emailChecker, err := runner.newEmailChecker()
+		if err != nil {
+			return nil, err
+		}
+		runner.emailChecker = emailChecker
+	}
+
 	// Initialize the custom rate limit
 	runner.rateLimit = &agent.CustomRateLimit{
 		Custom: mapsutil.SyncLockMap[string, uint]{
@@ -66,13 +137,121 @@ func NewRunner(options *Options) (*Runner, error) {
 		}
 	}
 
+	if options.OpenGraph != "" {
+		runner.graph = newOpenGraph()
+	}
+	if options.Report != "" {
+		runner.report = newReport()
+	}
+
+	if options.Template != "" || options.TemplateFile != "" {
+		tmpl, err := parseOutputTemplate(options.Template, options.TemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		runner.template = tmpl
+		if templateUsesIPs(tmpl) {
+			resolver, err := runner.agent.Resolver()
+			if err != nil {
+				return nil, err
+			}
+			runner.ipResolver = resolver
+		}
+	}
+
+	// The previous state is loaded before the sqlite store records the current run
+	if options.Diff != "" {
+		previous, err := loadPreviousState(options.Diff)
+		if err != nil {
+			return nil, err
+		}
+		runner.differ = newDiffer(previous)
+	}
+
+	if options.Webhook != "" {
+		runner.webhook = newWebhookSink(options)
+	}
+
+	if options.FailedOutput != "" {
+		outputWriter := NewOutputWriter(false)
+		file, err := outputWriter.createFile(options.FailedOutput, false)
+		if err != nil {
+			return nil, err
+		}
+		runner.failed = file
+	}
+
+	if options.SQLite != "" {
+		store, err := newSQLiteStore(options.SQLite, options)
+		if err != nil {
+			return nil, err
+		}
+		runner.store = store
+	}
+
 	return runner, nil
 }
 
+// newProxyPool creates the pool of the given proxies and removes the unreachable ones
+func newProxyPool(options *Options) (*session.ProxyPool, error) {
+	var proxies []string
+	if options.Proxy != "" {
+		proxies = append(proxies, options.Proxy)
+	}
+	if options.ProxyList != "" {
+		file, err := os.Open(options.ProxyList)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			proxy := strings.TrimSpace(scanner.Text())
+			if proxy != "" && !strings.HasPrefix(proxy, "#") {
+				proxies = append(proxies, proxy)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	proxyPool, err := session.NewProxyPool(proxies, options.ProxyRotation)
+	if err != nil {
+		return nil, err
+	}
+	proxyPool.PerProxyRateLimit = options.RateLimitPerProxy
+
+	if err := proxyPool.HealthCheck(context.Background(), time.Duration(options.Timeout)*time.Second); err != nil {
+		return nil, err
+	}
+	gologger.Info().Msgf("Rotating requests over %d proxies\n", len(proxyPool.Proxies()))
+	return proxyPool, nil
+}
+
 func (r *Runner) initializeAgent() {
 	r.agent = agent.New(r.options.Sources, r.options.ExcludeSources, r.options.All)
 }
 
+// startMetricsServer serves the prometheus metrics in the background
+func (r *Runner) startMetricsServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	metricsServer := &http.Server{
+		Addr:              r.options.MetricsListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		gologger.Info().Msgf("Serving metrics on %s/metrics\n", r.options.MetricsListen)
+		if err := metricsServer.ListenAndServe(); err != nil {
+			gologger.Error().Msgf("Could not serve metrics on %s: %s\n", r.options.MetricsListen, err)
+		}
+	}()
+}
+
 // RunEnumeration wraps RunEnumerationWithCtx with an empty context
 func (r *Runner) RunEnumeration() error {
 	ctx, _ := contextutil.WithValues(context.Background(), contextutil.ContextArg("All"), contextutil.ContextArg(strconv.FormatBool(r.options.All)))
@@ -83,18 +262,141 @@ func (r *Runner) RunEnumeration() error {
 func (r *Runner) RunEnumerationWithCtx(ctx context.Context) error {
 	outputs := []io.Writer{r.options.Output}
 
+	if r.har != nil {
+		defer r.writeHAR()
+	}
+	if r.failed != nil {
+		defer r.failed.Close()
+	}
+	if r.store != nil {
+		defer func() {
+			if err := r.store.close(); err != nil {
+				gologger.Error().Msgf("Could not close sqlite database %s: %s\n", r.options.SQLite, err)
+			}
+		}()
+	}
+
+	var err error
 	if len(r.options.Domain) > 0 {
 		domainsReader := strings.NewReader(strings.Join(r.options.Domain, "\n"))
-		return r.EnumerateMultipleDomainsWithCtx(ctx, domainsReader, outputs)
+		err = r.EnumerateMultipleDomainsWithCtx(ctx, domainsReader, outputs)
+	} else if r.options.Stdin {
+		// If we have STDIN input, treat it as multiple domains
+		err = r.EnumerateMultipleDomainsWithCtx(ctx, os.Stdin, outputs)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The removed domains of a tenant are only known once all its inputs ran
+	if r.differ != nil && ctx.Err() == nil {
+		if err := r.writeRemovedDomains(outputs); err != nil {
+			gologger.Error().Msgf("Could not write removed domains: %s\n", err)
+			return err
+		}
+	}
+
+	if r.graph != nil {
+		if err := r.writeOpenGraph(); err != nil {
+			gologger.Error().Msgf("Could not write opengraph file %s: %s\n", r.options.OpenGraph, err)
+			return err
+		}
+	}
+	r.flushWebhook(ctx)
+	if r.report != nil {
+		if err := r.writeReport(); err != nil {
+			gologger.Error().Msgf("Could not write report %s: %s\n", r.options.Report, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOpenGraph writes the collected attack graph to the opengraph file
+func (r *Runner) writeOpenGraph() error {
+	outputWriter := NewOutputWriter(r.options.JSON)
+	file, err := outputWriter.createFile(r.options.OpenGraph, false)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return r.graph.write(file)
+}
+
+// writeHAR writes the recorded requests to the har file
+func (r *Runner) writeHAR() {
+	outputWriter := NewOutputWriter(true)
+	file, err := outputWriter.createFile(r.options.HAR, false)
+	if err != nil {
+		gologger.Error().Msgf("Could not create har file %s: %s\n", r.options.HAR, err)
+		return
+	}
+	defer file.Close()
+
+	if err := r.har.Write(file); err != nil {
+		gologger.Error().Msgf("Could not write har file %s: %s\n", r.options.HAR, err)
+	}
+}
+
+// writeReport renders the run report to the report file
+func (r *Runner) writeReport() error {
+	outputWriter := NewOutputWriter(r.options.JSON)
+	file, err := outputWriter.createFile(r.options.Report, false)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return r.report.write(file, reportFormat(r.options.Report), r.options)
+}
+
+// writeRemovedDomains writes the domains removed from every tenant of the run
+// along with the output of the first input of the tenant
+func (r *Runner) writeRemovedDomains(writers []io.Writer) error {
+	for _, removal := range r.differ.removed() {
+		if err := r.writeChangedHost(removal.input, removal.changes, writers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChangedHost writes changes to the writers and the output file of the input
+func (r *Runner) writeChangedHost(input string, changes []resultChange, writers []io.Writer) error {
+	outputWriter := NewOutputWriter(r.options.JSON)
+	if outputFile := r.outputFile(input); outputFile != "" {
+		file, err := outputWriter.createFile(outputFile, true)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writers = append(append([]io.Writer(nil), writers...), file)
 	}
 
-	// If we have STDIN input, treat it as multiple domains
-	if r.options.Stdin {
-		return r.EnumerateMultipleDomainsWithCtx(ctx, os.Stdin, outputs)
+	for _, writer := range writers {
+		if err := outputWriter.WriteChangedHost(input, changes, writer); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// outputFile returns the file the results of an input are written to, if any
+func (r *Runner) outputFile(domain string) string {
+	switch {
+	case r.options.OutputFile != "":
+		return r.options.OutputFile
+	case r.options.OutputDirectory != "":
+		outputFile := path.Join(r.options.OutputDirectory, domain)
+		if r.options.JSON {
+			return outputFile + ".json"
+		}
+		return outputFile + ".txt"
+	}
+	return ""
+}
+
 // EnumerateMultipleDomains wraps EnumerateMultipleDomainsWithCtx with an empty context
 func (r *Runner) EnumerateMultipleDomains(reader io.Reader, writers []io.Writer) error {
 	ctx, _ := contextutil.WithValues(context.Background(), contextutil.ContextArg("All"), contextutil.ContextArg(strconv.FormatBool(r.options.All)))
@@ -130,12 +432,7 @@ func (r *Runner) EnumerateMultipleDomainsWithCtx(ctx context.Context, reader io.
 
 			file.Close()
 		} else if r.options.OutputDirectory != "" {
-			outputFile := path.Join(r.options.OutputDirectory, domain)
-			if r.options.JSON {
-				outputFile += ".json"
-			} else {
-				outputFile += ".txt"
-			}
+			outputFile := r.outputFile(domain)
 
 			outputWriter := NewOutputWriter(r.options.JSON)
 			file, err = outputWriter.createFile(outputFile, false)
@@ -0,0 +1,539 @@
+package runner
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/xid"
+
+	"github.com/projectdiscovery/gologger"
+
+	"golang.org/x/exp/maps"
+)
+
+// Status of an enumeration job submitted to the server
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobFinished = "finished"
+	// jobCancelled is a job still queued when the server shut down
+	jobCancelled = "cancelled"
+)
+
+// maxJobRequestSize is the maximum size of a job submission body
+const maxJobRequestSize = 1 << 20
+
+// jobRetention is how long finished jobs and their results are kept
+const jobRetention = time.Hour
+
+// maxJobs is the maximum number of jobs kept, queued, running or finished
+const maxJobs = 1024
+
+// server exposes the agent over http. Jobs are run by a fixed number of
+// workers in the order they were submitted, all sharing the global rate limiter.
+type server struct {
+	runner *Runner
+	mutex  sync.RWMutex
+	jobs   map[string]*job
+	queue  chan *job
+	// submitted and dequeued count the jobs put in and taken from the queue,
+	// telling the position of a queued job
+	submitted int
+	dequeued  int
+	// done is closed when the server shuts down
+	done <-chan struct{}
+}
+
+// job is an enumeration of one or more domains submitted to the server
+type job struct {
+	mutex  sync.Mutex
+	id     string
+	status string
+	// sequence is the number of jobs submitted before this one
+	sequence  int
+	domains   []string
+	completed int
+	// results holds the domains as jsonSourceResult and the findings as jsonFindingResult
+	results    []interface{}
+	errors     []jobError
+	statuses   map[string]string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	// updated is closed and replaced whenever the job changes
+	updated chan struct{}
+}
+
+type jobRequest struct {
+	Domains []string `json:"domains"`
+}
+
+type jobError struct {
+	Input string `json:"input"`
+	Error string `json:"error"`
+}
+
+type jobStatus struct {
+	ID        string   `json:"id"`
+	Status    string   `json:"status"`
+	Domains   []string `json:"domains"`
+	Completed int      `json:"completed"`
+	Results   int      `json:"results"`
+	// QueuePosition is the number of jobs to start before a queued job, itself included
+	QueuePosition int               `json:"queue_position,omitempty"`
+	Statuses      map[string]string `json:"statuses,omitempty"`
+	Errors        []jobError        `json:"errors,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	StartedAt     *time.Time        `json:"started_at,omitempty"`
+	FinishedAt    *time.Time        `json:"finished_at,omitempty"`
+}
+
+// RunServer wraps RunServerWithCtx with an empty context
+func (r *Runner) RunServer() error {
+	return r.RunServerWithCtx(context.Background())
+}
+
+// RunServerWithCtx serves the enumeration api until the context is
+// cancelled or the process is interrupted
+func (r *Runner) RunServerWithCtx(ctx context.Context) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	multiRateLimiter, err := r.agent.BuildMultiRateLimiter(ctx, r.options.RateLimit, r.rateLimit, r.proxyPool)
+	if err != nil {
+		return err
+	}
+	r.multiRateLimiter = multiRateLimiter
+
+	if r.har != nil {
+		defer r.writeHAR()
+	}
+	if r.store != nil {
+		defer func() {
+			if err := r.store.close(); err != nil {
+				gologger.Error().Msgf("Could not close sqlite database %s: %s\n", r.options.SQLite, err)
+			}
+		}()
+	}
+
+	// The results of an interrupted job are still posted
+	defer r.flushWebhookOnShutdown(ctx)
+
+	s := newServer(ctx, r)
+
+	httpServer := &http.Server{
+		Addr:              r.options.Listen,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if r.options.APIToken == "" && !isLoopback(r.options.Listen) {
+		gologger.Warning().Msgf("The api server listens on %s without an api token, anyone reaching it can submit jobs\n", r.options.Listen)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		gologger.Info().Msgf("Listening on %s\n", r.options.Listen)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newServer creates the server and starts its workers, which stop when the context is done
+func newServer(ctx context.Context, r *Runner) *server {
+	s := &server{
+		runner: r,
+		jobs:   make(map[string]*job),
+		queue:  make(chan *job, maxJobs),
+		done:   ctx.Done(),
+	}
+	for i := 0; i < r.options.ServerWorkers; i++ {
+		go s.work(ctx)
+	}
+	return s
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.handleSubmit)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	return s.authenticate(mux)
+}
+
+// authenticate requires the api token as a bearer token when one is configured
+func (s *server) authenticate(next http.Handler) http.Handler {
+	token := s.runner.options.APIToken
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// work processes the queued jobs one after the other, and cancels the jobs
+// left in the queue once the context is done
+func (s *server) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			s.cancelQueued()
+			return
+		case j := <-s.queue:
+			s.mutex.Lock()
+			s.dequeued++
+			s.mutex.Unlock()
+			// Both cases are ready when a job is queued as the server shuts down
+			if ctx.Err() != nil {
+				j.cancel()
+				continue
+			}
+			s.run(ctx, j)
+		}
+	}
+}
+
+// cancelQueued marks the jobs still queued as cancelled, which ends the
+// result streams waiting for them
+func (s *server) cancelQueued() {
+	for {
+		select {
+		case j := <-s.queue:
+			j.cancel()
+		default:
+			return
+		}
+	}
+}
+
+func (s *server) run(ctx context.Context, j *job) {
+	j.update(func() {
+		j.status = jobRunning
+		j.startedAt = time.Now().UTC()
+	})
+
+	for _, domain := range j.domains {
+		if ctx.Err() != nil {
+			break
+		}
+
+		gologger.Info().Msgf("Enumerating domains for %s (job %s)\n", domain, j.id)
+		result := s.runner.enumerateDomain(ctx, domain)
+		s.runner.persist(ctx, result)
+
+		j.update(func() {
+			j.completed++
+			j.statuses[domain] = result.Status
+			for _, data := range templateResults(result) {
+				if data.Domain == "" {
+					j.results = append(j.results, jsonFindingResult{Input: data.Input, Source: data.Source, Type: data.Type, Value: data.Value, Reference: data.Reference, Status: data.Status})
+					continue
+				}
+				j.results = append(j.results, jsonSourceResult{Domain: data.Domain, Input: data.Input, Source: data.Source, Reference: data.Reference, Status: data.Status})
+			}
+			for _, err := range result.Errors {
+				j.errors = append(j.errors, jobError{Input: domain, Error: err})
+			}
+		})
+	}
+
+	s.runner.flushWebhook(ctx)
+
+	j.update(func() {
+		j.status = jobFinished
+		j.finishedAt = time.Now().UTC()
+	})
+}
+
+// handleSubmit queues a new job for the domains in the request body
+func (s *server) handleSubmit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var request jobRequest
+	if err := jsoniter.NewDecoder(http.MaxBytesReader(w, req.Body, maxJobRequestSize)).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err))
+		return
+	}
+
+	var domains []string
+	for _, domain := range request.Domains {
+		domain = replacer.Replace(preprocessDomain(domain))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "no domains provided")
+		return
+	}
+
+	j := &job{
+		id:        xid.New().String(),
+		status:    jobQueued,
+		domains:   domains,
+		statuses:  make(map[string]string),
+		createdAt: time.Now().UTC(),
+		updated:   make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	s.evictLocked(time.Now().UTC())
+	if len(s.jobs) >= maxJobs {
+		s.mutex.Unlock()
+		writeJSONError(w, http.StatusServiceUnavailable, "too many jobs")
+		return
+	}
+	// The job is queued under the lock so the sequence follows the queue order
+	select {
+	case s.queue <- j:
+		j.sequence = s.submitted
+		s.submitted++
+		s.jobs[j.id] = j
+	default:
+		s.mutex.Unlock()
+		writeJSONError(w, http.StatusServiceUnavailable, "too many queued jobs")
+		return
+	}
+	s.mutex.Unlock()
+
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, http.StatusAccepted, s.snapshot(j))
+}
+
+// evictLocked forgets the jobs finished longer than the retention ago and,
+// when there are still too many jobs, the finished ones from the oldest
+func (s *server) evictLocked(now time.Time) {
+	finished := make(map[*job]time.Time)
+	for id, j := range s.jobs {
+		j.mutex.Lock()
+		finishedAt := j.finishedAt
+		j.mutex.Unlock()
+		if finishedAt.IsZero() {
+			continue
+		}
+		if now.Sub(finishedAt) > jobRetention {
+			delete(s.jobs, id)
+			continue
+		}
+		finished[j] = finishedAt
+	}
+
+	if len(s.jobs) < maxJobs {
+		return
+	}
+	oldest := make([]*job, 0, len(finished))
+	for j := range finished {
+		oldest = append(oldest, j)
+	}
+	sort.Slice(oldest, func(i, k int) bool {
+		return finished[oldest[i]].Before(finished[oldest[k]])
+	})
+	for _, j := range oldest {
+		if len(s.jobs) < maxJobs {
+			return
+		}
+		delete(s.jobs, j.id)
+	}
+}
+
+// handleJob serves the status (/jobs/{id}) and results (/jobs/{id}/results) of a job
+func (s *server) handleJob(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, resource, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/jobs/"), "/")
+
+	s.mutex.RLock()
+	j, ok := s.jobs[id]
+	s.mutex.RUnlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	switch resource {
+	case "":
+		writeJSON(w, http.StatusOK, s.snapshot(j))
+	case "results":
+		s.streamResults(w, req, j)
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// streamResults streams the results of a job as they are found, either as
+// server-sent events or as newline delimited json, until the job is finished
+func (s *server) streamResults(w http.ResponseWriter, req *http.Request, j *job) {
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	var sent int
+	for {
+		j.mutex.Lock()
+		results := j.results[sent:]
+		finished := j.finished()
+		updated := j.updated
+		j.mutex.Unlock()
+
+		for _, result := range results {
+			data, err := jsoniter.Marshal(result)
+			if err != nil {
+				return
+			}
+			if sse {
+				_, err = fmt.Fprintf(w, "event: result\ndata: %s\n\n", data)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", data)
+			}
+			if err != nil {
+				return
+			}
+		}
+		sent += len(results)
+
+		if finished {
+			if sse {
+				fmt.Fprintf(w, "event: done\ndata: {\"id\":%q}\n\n", j.id)
+			}
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-updated:
+		}
+	}
+}
+
+// update changes the job and wakes up everyone waiting for changes
+func (j *job) update(change func()) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	change()
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// cancel ends a job that never ran
+func (j *job) cancel() {
+	j.update(func() {
+		j.status = jobCancelled
+		j.finishedAt = time.Now().UTC()
+	})
+}
+
+// finished reports whether the job ended, either run or cancelled
+func (j *job) finished() bool {
+	return j.status == jobFinished || j.status == jobCancelled
+}
+
+// snapshot returns the status of the job along with its position in the queue
+func (s *server) snapshot(j *job) jobStatus {
+	status := j.snapshot()
+	if status.Status == jobQueued {
+		s.mutex.RLock()
+		// A job just taken from the queue is the next one to start
+		status.QueuePosition = max(j.sequence-s.dequeued+1, 1)
+		s.mutex.RUnlock()
+	}
+	return status
+}
+
+func (j *job) snapshot() jobStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	status := jobStatus{
+		ID:        j.id,
+		Status:    j.status,
+		Domains:   j.domains,
+		Completed: j.completed,
+		Results:   len(j.results),
+		Statuses:  maps.Clone(j.statuses),
+		Errors:    append([]jobError(nil), j.errors...),
+		CreatedAt: j.createdAt,
+	}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		status.StartedAt = &startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	return status
+}
+
+// isLoopback reports whether the listen address only accepts local connections
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = jsoniter.NewEncoder(w).Encode(data)
+}
+
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
@@ -0,0 +1,215 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/upmux/tenantfinder/pkg/agent"
+	"github.com/upmux/tenantfinder/pkg/session"
+
+	mapsutil "github.com/projectdiscovery/utils/maps"
+)
+
+// newTestServer serves the api with the autodiscover source answering from
+// its recorded responses, so the jobs run without reaching the network. The
+// returned function shuts the server down.
+func newTestServer(t *testing.T, token string, workers int) (*httptest.Server, *server, context.CancelFunc) {
+	t.Helper()
+
+	replay, err := session.LoadHARReplay("../../pkg/source/autodiscover/testdata/autodiscover.har")
+	if err != nil {
+		t.Fatalf("could not load har: %s", err)
+	}
+	r := &Runner{
+		options:   &Options{Timeout: 5, MaxEnumerationTime: 1, APIToken: token, ServerWorkers: workers},
+		agent:     agent.New([]string{"autodiscover"}, nil, false),
+		rateLimit: &agent.CustomRateLimit{Custom: mapsutil.SyncLockMap[string, uint]{Map: make(map[string]uint)}},
+		replay:    replay,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newServer(ctx, r)
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+	})
+	return ts, s, cancel
+}
+
+// submitJob submits a job for the domains and returns its status
+func submitJob(t *testing.T, ts *httptest.Server, body string) jobStatus {
+	t.Helper()
+	response, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not submit job: %s", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("status code = %d, want %d", response.StatusCode, http.StatusAccepted)
+	}
+	var status jobStatus
+	if err := jsoniter.NewDecoder(response.Body).Decode(&status); err != nil {
+		t.Fatalf("could not parse job: %s", err)
+	}
+	return status
+}
+
+func TestServerJob(t *testing.T) {
+	ts, _, _ := newTestServer(t, "", 1)
+
+	response, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"domains":["Contoso.com","example.org",""]}`))
+	if err != nil {
+		t.Fatalf("could not submit job: %s", err)
+	}
+	var submitted jobStatus
+	err = jsoniter.NewDecoder(response.Body).Decode(&submitted)
+	response.Body.Close()
+	if err != nil {
+		t.Fatalf("could not parse job: %s", err)
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("status code = %d, want %d", response.StatusCode, http.StatusAccepted)
+	}
+	if location := response.Header.Get("Location"); location != "/jobs/"+submitted.ID {
+		t.Errorf("location = %q, want /jobs/%s", location, submitted.ID)
+	}
+	if want := []string{"contoso.com", "example.org"}; !reflect.DeepEqual(submitted.Domains, want) {
+		t.Errorf("domains = %v, want %v", submitted.Domains, want)
+	}
+
+	// The stream only ends once the job is finished
+	response, err = http.Get(ts.URL + "/jobs/" + submitted.ID + "/results")
+	if err != nil {
+		t.Fatalf("could not stream results: %s", err)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("content type = %q, want application/x-ndjson", contentType)
+	}
+	var results []jsonFindingResult
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		var result jsonFindingResult
+		if err := jsoniter.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("could not parse result %q: %s", scanner.Text(), err)
+		}
+		results = append(results, result)
+	}
+	response.Body.Close()
+	if len(results) != 4 {
+		t.Errorf("streamed %d results, want 4", len(results))
+	}
+	for _, result := range results {
+		if result.Input != "contoso.com" || result.Source != "autodiscover" || result.Status != statusOK {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	response, err = http.Get(ts.URL + "/jobs/" + submitted.ID)
+	if err != nil {
+		t.Fatalf("could not poll job: %s", err)
+	}
+	var polled jobStatus
+	err = jsoniter.NewDecoder(response.Body).Decode(&polled)
+	response.Body.Close()
+	if err != nil {
+		t.Fatalf("could not parse job: %s", err)
+	}
+	if polled.Status != jobFinished || polled.Completed != 2 || polled.Results != len(results) || polled.FinishedAt == nil {
+		t.Errorf("polled job = %+v, want finished with 2 inputs and %d results", polled, len(results))
+	}
+	if want := map[string]string{"contoso.com": statusOK, "example.org": statusNotInTenant}; !reflect.DeepEqual(polled.Statuses, want) {
+		t.Errorf("statuses = %v, want %v", polled.Statuses, want)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	ts, _, _ := newTestServer(t, "secret", 1)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		want   int
+	}{
+		{"missing token", http.MethodPost, "/jobs", `{"domains":["contoso.com"]}`, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "/jobs", `{"domains":["contoso.com"]}`, "guess", http.StatusUnauthorized},
+		{"no domains", http.MethodPost, "/jobs", `{"domains":[" "]}`, "secret", http.StatusBadRequest},
+		{"invalid body", http.MethodPost, "/jobs", `{"domains":`, "secret", http.StatusBadRequest},
+		{"list jobs", http.MethodGet, "/jobs", "", "secret", http.StatusMethodNotAllowed},
+		{"unknown job", http.MethodGet, "/jobs/unknown", "", "secret", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("could not create request: %s", err)
+			}
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("could not send request: %s", err)
+			}
+			response.Body.Close()
+			if response.StatusCode != test.want {
+				t.Errorf("status code = %d, want %d", response.StatusCode, test.want)
+			}
+		})
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	// Without workers the jobs stay queued until the server shuts down
+	ts, s, shutdown := newTestServer(t, "", 0)
+
+	first := submitJob(t, ts, `{"domains":["contoso.com"]}`)
+	second := submitJob(t, ts, `{"domains":["fabrikam.com"]}`)
+	if first.Status != jobQueued || first.QueuePosition != 1 || second.QueuePosition != 2 {
+		t.Errorf("queued jobs = %+v and %+v, want queue positions 1 and 2", first, second)
+	}
+
+	streamed := make(chan error, 1)
+	go func() {
+		response, err := http.Get(ts.URL + "/jobs/" + second.ID + "/results")
+		if err == nil {
+			_, err = io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+		streamed <- err
+	}()
+
+	// A worker seeing the server shut down cancels the queued jobs
+	shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.work(ctx)
+	select {
+	case err := <-streamed:
+		if err != nil {
+			t.Errorf("could not stream results: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the result stream did not end on shutdown")
+	}
+
+	for _, id := range []string{first.ID, second.ID} {
+		s.mutex.RLock()
+		status := s.snapshot(s.jobs[id])
+		s.mutex.RUnlock()
+		if status.Status != jobCancelled || status.FinishedAt == nil || status.QueuePosition != 0 {
+			t.Errorf("job after shutdown = %+v, want cancelled", status)
+		}
+	}
+}
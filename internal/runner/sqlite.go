@@ -0,0 +1,259 @@
+package runner
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	// Registers the pure go sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the history tables. Every entity row carries the
+// time it was first and last seen so repeated runs update rather than
+// duplicate rows, while the run_ tables keep what every run saw so the
+// history of a domain or tenant can be recovered.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	version     TEXT NOT NULL,
+	sources     TEXT NOT NULL,
+	started_at  TEXT NOT NULL,
+	finished_at TEXT
+);
+CREATE TABLE IF NOT EXISTS tenants (
+	name        TEXT PRIMARY KEY,
+	first_seen  TEXT NOT NULL,
+	last_seen   TEXT NOT NULL,
+	last_run_id INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS inputs (
+	name        TEXT PRIMARY KEY,
+	tenant      TEXT REFERENCES tenants(name),
+	first_seen  TEXT NOT NULL,
+	last_seen   TEXT NOT NULL,
+	last_run_id INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS domains (
+	name        TEXT PRIMARY KEY,
+	tenant      TEXT REFERENCES tenants(name),
+	first_seen  TEXT NOT NULL,
+	last_seen   TEXT NOT NULL,
+	last_run_id INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS observations (
+	domain      TEXT NOT NULL REFERENCES domains(name),
+	input       TEXT NOT NULL REFERENCES inputs(name),
+	source      TEXT NOT NULL,
+	first_seen  TEXT NOT NULL,
+	last_seen   TEXT NOT NULL,
+	last_run_id INTEGER NOT NULL REFERENCES runs(id),
+	PRIMARY KEY (domain, input, source)
+);
+CREATE TABLE IF NOT EXISTS findings (
+	input       TEXT NOT NULL REFERENCES inputs(name),
+	source      TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	value       TEXT NOT NULL,
+	reference   TEXT NOT NULL,
+	first_seen  TEXT NOT NULL,
+	last_seen   TEXT NOT NULL,
+	last_run_id INTEGER NOT NULL REFERENCES runs(id),
+	PRIMARY KEY (input, source, type, value)
+);
+CREATE TABLE IF NOT EXISTS run_inputs (
+	run_id      INTEGER NOT NULL REFERENCES runs(id),
+	input       TEXT NOT NULL REFERENCES inputs(name),
+	tenant      TEXT REFERENCES tenants(name),
+	status      TEXT NOT NULL,
+	PRIMARY KEY (run_id, input)
+);
+CREATE TABLE IF NOT EXISTS run_observations (
+	run_id      INTEGER NOT NULL REFERENCES runs(id),
+	domain      TEXT NOT NULL REFERENCES domains(name),
+	input       TEXT NOT NULL REFERENCES inputs(name),
+	source      TEXT NOT NULL,
+	PRIMARY KEY (run_id, domain, input, source)
+);
+CREATE TABLE IF NOT EXISTS run_findings (
+	run_id      INTEGER NOT NULL REFERENCES runs(id),
+	input       TEXT NOT NULL REFERENCES inputs(name),
+	source      TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	value       TEXT NOT NULL,
+	PRIMARY KEY (run_id, input, source, type, value)
+);
+`
+
+// sqliteStore persists the results of every run into a sqlite database
+type sqliteStore struct {
+	db    *sql.DB
+	runID int64
+}
+
+// newSQLiteStore opens the database at path, creates the schema
+// if required and records the start of a new run
+func newSQLiteStore(path string, options *Options) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only supports a single writer at a time
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	sources := "default"
+	if options.All {
+		sources = "all"
+	} else if len(options.Sources) > 0 {
+		sources = strings.Join(options.Sources, ",")
+	}
+
+	res, err := db.Exec(`INSERT INTO runs (version, sources, started_at) VALUES (?, ?, ?)`, version, sources, sqliteTime(time.Now()))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{db: db, runID: runID}, nil
+}
+
+// add upserts the tenant, input, domains, source observations and findings
+// of an input and records them for the current run
+func (s *sqliteStore) add(result *inputResult) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := sqliteTime(time.Now())
+
+	var tenant interface{}
+	if len(result.Hosts) > 0 {
+		name := tenantName(result.Input, result.Hosts)
+		tenant = name
+		_, err = tx.Exec(`INSERT INTO tenants (name, first_seen, last_seen, last_run_id) VALUES (?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET last_seen = excluded.last_seen, last_run_id = excluded.last_run_id`,
+			name, now, now, s.runID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO inputs (name, tenant, first_seen, last_seen, last_run_id) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET tenant = COALESCE(excluded.tenant, inputs.tenant), last_seen = excluded.last_seen, last_run_id = excluded.last_run_id`,
+		result.Input, tenant, now, now, s.runID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO run_inputs (run_id, input, tenant, status) VALUES (?, ?, ?, ?)
+		ON CONFLICT (run_id, input) DO UPDATE SET tenant = COALESCE(excluded.tenant, run_inputs.tenant), status = excluded.status`,
+		s.runID, result.Input, tenant, result.Status)
+	if err != nil {
+		return err
+	}
+
+	for host, sources := range result.Sources {
+		_, err = tx.Exec(`INSERT INTO domains (name, tenant, first_seen, last_seen, last_run_id) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET tenant = excluded.tenant, last_seen = excluded.last_seen, last_run_id = excluded.last_run_id`,
+			host, tenant, now, now, s.runID)
+		if err != nil {
+			return err
+		}
+
+		for source := range sources {
+			_, err = tx.Exec(`INSERT INTO observations (domain, input, source, first_seen, last_seen, last_run_id) VALUES (?, ?, ?, ?, ?, ?)
+				ON CONFLICT (domain, input, source) DO UPDATE SET last_seen = excluded.last_seen, last_run_id = excluded.last_run_id`,
+				host, result.Input, source, now, now, s.runID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT OR IGNORE INTO run_observations (run_id, domain, input, source) VALUES (?, ?, ?, ?)`,
+				s.runID, host, result.Input, source)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, finding := range result.Findings {
+		_, err = tx.Exec(`INSERT INTO findings (input, source, type, value, reference, first_seen, last_seen, last_run_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (input, source, type, value) DO UPDATE SET reference = excluded.reference, last_seen = excluded.last_seen, last_run_id = excluded.last_run_id`,
+			result.Input, finding.Source, finding.Type.String(), finding.Value, finding.Reference, now, now, s.runID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT OR IGNORE INTO run_findings (run_id, input, source, type, value) VALUES (?, ?, ?, ?, ?)`,
+			s.runID, result.Input, finding.Source, finding.Type.String(), finding.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// close records the end of the run and closes the database
+func (s *sqliteStore) close() error {
+	_, err := s.db.Exec(`UPDATE runs SET finished_at = ? WHERE id = ?`, sqliteTime(time.Now()), s.runID)
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// loadSQLiteState reads the domains and findings of every input during
+// the last run that returned results for it
+func loadSQLiteState(path string) (map[string]map[resultKey]string, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Databases written before findings were stored have no findings table
+	var hasFindings int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'findings'`).Scan(&hasFindings); err != nil {
+		return nil, err
+	}
+
+	results := `SELECT input, 'domain' AS type, domain AS value, source, last_run_id FROM observations`
+	if hasFindings > 0 {
+		results += ` UNION ALL SELECT input, type, value, source, last_run_id FROM findings`
+	}
+	rows, err := db.Query(`WITH results AS (` + results + `)
+		SELECT r.input, r.type, r.value, r.source FROM results r
+		WHERE r.last_run_id = (SELECT MAX(last_run_id) FROM results WHERE input = r.input)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inputs := make(map[string]map[resultKey]string)
+	for rows.Next() {
+		var input, source string
+		var key resultKey
+		if err := rows.Scan(&input, &key.Type, &key.Value, &source); err != nil {
+			return nil, err
+		}
+		if _, ok := inputs[input]; !ok {
+			inputs[input] = make(map[resultKey]string)
+		}
+		inputs[input][key] = source
+	}
+	return inputs, rows.Err()
+}
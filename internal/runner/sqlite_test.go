@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// storeRun records a single result as its own run of the store
+func storeRun(t *testing.T, path string, result *inputResult) {
+	t.Helper()
+	store, err := newSQLiteStore(path, &Options{})
+	if err != nil {
+		t.Fatalf("could not open store: %s", err)
+	}
+	if err := store.add(result); err != nil {
+		t.Fatalf("could not add result: %s", err)
+	}
+	if err := store.close(); err != nil {
+		t.Fatalf("could not close store: %s", err)
+	}
+}
+
+func TestSQLiteStoreUpsert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	finding := source.Result{Source: "autodiscover", Type: source.Endpoint, Value: "https://autodiscover.contoso.com"}
+	first := diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com"}, finding)
+	first.Sources = map[string]map[string]struct{}{
+		"contoso.com":             {"aad": {}},
+		"contoso.onmicrosoft.com": {"aad": {}},
+	}
+	storeRun(t, path, first)
+
+	// Moves the first run back in time, as both runs happen within the same second
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+	for _, table := range []string{"tenants", "inputs", "domains", "observations", "findings"} {
+		if _, err := db.Exec(`UPDATE ` + table + ` SET first_seen = '2020-01-01T00:00:00Z', last_seen = '2020-01-01T00:00:00Z'`); err != nil {
+			t.Fatalf("could not age %s: %s", table, err)
+		}
+	}
+	db.Close()
+
+	second := diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com"}, finding)
+	second.Sources = map[string]map[string]struct{}{
+		"contoso.com":             {"aad": {}, "crtsh": {}},
+		"contoso.onmicrosoft.com": {"aad": {}},
+	}
+	storeRun(t, path, second)
+
+	db, err = sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"single tenant row", `SELECT COUNT(*) FROM tenants`, 1},
+		{"single input row", `SELECT COUNT(*) FROM inputs`, 1},
+		{"single row per domain", `SELECT COUNT(*) FROM domains`, 2},
+		{"single row per observation", `SELECT COUNT(*) FROM observations`, 3},
+		{"single finding row", `SELECT COUNT(*) FROM findings`, 1},
+		{"first seen kept", `SELECT COUNT(*) FROM domains WHERE first_seen = '2020-01-01T00:00:00Z'`, 2},
+		{"last seen updated", `SELECT COUNT(*) FROM domains WHERE last_seen > '2020-01-01T00:00:00Z' AND last_run_id = 2`, 2},
+		{"tenant first seen kept", `SELECT COUNT(*) FROM tenants WHERE first_seen = '2020-01-01T00:00:00Z' AND last_seen > first_seen`, 1},
+		{"finding first seen kept", `SELECT COUNT(*) FROM findings WHERE first_seen = '2020-01-01T00:00:00Z' AND last_run_id = 2`, 1},
+		{"new source first seen", `SELECT COUNT(*) FROM observations WHERE source = 'crtsh' AND first_seen > '2020-01-01T00:00:00Z'`, 1},
+		{"every run recorded", `SELECT COUNT(*) FROM run_observations`, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got int
+			if err := db.QueryRow(test.query).Scan(&got); err != nil {
+				t.Fatalf("could not query: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("%s = %d, want %d", test.query, got, test.want)
+			}
+		})
+	}
+}
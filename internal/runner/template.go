@@ -0,0 +1,229 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/projectdiscovery/gologger"
+
+	"github.com/upmux/tenantfinder/pkg/emailsec"
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// resolveWorkers is the number of domains resolved concurrently for the output template
+const resolveWorkers = 10
+
+// TemplateResult is the data a custom output template is executed
+// against, once for every discovered domain and then for every finding.
+type TemplateResult struct {
+	Type      string            // Type is domain, or the type of the finding such as endpoint or certificate
+	Value     string            // Value is the discovered domain or the value of the finding
+	Reference string            // Reference tells where the domain or finding was found, when the source told
+	Domain    string            // Domain is the discovered domain, empty for findings
+	Input     string            // Input is the domain the enumeration was run for
+	Source    string            // Source is the first source that returned the domain or finding
+	Sources   []string          // Sources contains all sources that returned the domain or finding
+	Tenant    TemplateTenant    // Tenant contains metadata about the tenant the domain belongs to
+	IPs       []string          // IPs contains the ipv4 addresses of the domain, resolved when the template uses them
+	Status    string            // Status is the status of the input (ok, not_in_tenant, throttled, error)
+	Email     *emailsec.Posture // Email is the email security posture of the domain when it was checked
+}
+
+// TemplateTenant contains the tenant metadata available to output templates
+type TemplateTenant struct {
+	Name    string   // Name is the initial onmicrosoft.com domain of the tenant, or the input if unknown
+	Domains int      // Domains is the number of domains found in the tenant
+	Issuers []string // Issuers contains the federation token issuers of the tenant
+}
+
+var templateFuncs = template.FuncMap{
+	"join":    strings.Join,
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": strings.ReplaceAll,
+	"json": func(v interface{}) (string, error) {
+		return jsoniter.MarshalToString(v)
+	},
+}
+
+// parseOutputTemplate parses the output template given inline or
+// read from a file
+func parseOutputTemplate(text, file string) (*template.Template, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+
+	// Every result is written on its own line
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return template.New("output").Funcs(templateFuncs).Parse(text)
+}
+
+// templateUsesIPs reports whether the template reads the addresses of the
+// domains, which are only resolved for the templates that do
+func templateUsesIPs(tmpl *template.Template) bool {
+	for _, associated := range tmpl.Templates() {
+		if associated.Tree != nil && usesField(associated.Tree.Root, "IPs") {
+			return true
+		}
+	}
+	return false
+}
+
+// usesField reports whether the node reads the field anywhere below it
+func usesField(node parse.Node, field string) bool {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return false
+		}
+		for _, child := range node.Nodes {
+			if usesField(child, field) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesField(node.Pipe, field)
+	case *parse.IfNode:
+		return usesBranchField(&node.BranchNode, field)
+	case *parse.RangeNode:
+		return usesBranchField(&node.BranchNode, field)
+	case *parse.WithNode:
+		return usesBranchField(&node.BranchNode, field)
+	case *parse.TemplateNode:
+		return usesField(node.Pipe, field)
+	case *parse.PipeNode:
+		if node == nil {
+			return false
+		}
+		for _, command := range node.Cmds {
+			for _, arg := range command.Args {
+				if usesField(arg, field) {
+					return true
+				}
+			}
+		}
+	case *parse.FieldNode:
+		return containsIdent(node.Ident, field)
+	case *parse.VariableNode:
+		return containsIdent(node.Ident, field)
+	case *parse.ChainNode:
+		return containsIdent(node.Field, field) || usesField(node.Node, field)
+	}
+	return false
+}
+
+func usesBranchField(node *parse.BranchNode, field string) bool {
+	return usesField(node.Pipe, field) || usesField(node.List, field) || usesField(node.ElseList, field)
+}
+
+func containsIdent(idents []string, field string) bool {
+	for _, ident := range idents {
+		if ident == field {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveIPs resolves the ipv4 addresses of every found domain, leaving out
+// the domains that do not resolve
+func resolveIPs(ctx context.Context, resolver *resolve.Resolver, hosts map[string]resolve.HostEntry) map[string][]string {
+	gologger.Verbose().Msgf("Resolving %d domains for the output template\n", len(hosts))
+
+	addresses := make(map[string][]string, len(hosts))
+	mutex := &sync.Mutex{}
+	domains := make(chan string)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < resolveWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range domains {
+				ips, err := resolver.A(domain)
+				if err != nil || len(ips) == 0 {
+					continue
+				}
+				sort.Strings(ips)
+				mutex.Lock()
+				addresses[domain] = ips
+				mutex.Unlock()
+			}
+		}()
+	}
+	for domain := range hosts {
+		if ctx.Err() != nil {
+			break
+		}
+		domains <- domain
+	}
+	close(domains)
+	wg.Wait()
+	return addresses
+}
+
+// templateResults builds the template data for every domain and finding of an input
+func templateResults(result *inputResult) []TemplateResult {
+	tenant := TemplateTenant{
+		Name:    tenantName(result.Input, result.Hosts),
+		Domains: len(result.Hosts),
+	}
+	for uri := range result.Issuers {
+		tenant.Issuers = append(tenant.Issuers, uri)
+	}
+	sort.Strings(tenant.Issuers)
+
+	data := make([]TemplateResult, 0, len(result.Hosts)+len(result.Findings))
+	for host, entry := range result.Hosts {
+		sources := make([]string, 0, len(result.Sources[host]))
+		for source := range result.Sources[host] {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+
+		data = append(data, TemplateResult{
+			Type:      source.Domain.String(),
+			Value:     host,
+			Reference: entry.Reference,
+			Domain:    host,
+			Input:     result.Input,
+			Source:    entry.Source,
+			Sources:   sources,
+			Tenant:    tenant,
+			IPs:       result.IPs[host],
+			Status:    result.Status,
+			Email:     result.Email[host],
+		})
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Domain < data[j].Domain
+	})
+
+	// The findings are already sorted
+	for _, finding := range result.Findings {
+		data = append(data, TemplateResult{
+			Type:      finding.Type.String(),
+			Value:     finding.Value,
+			Reference: finding.Reference,
+			Input:     result.Input,
+			Source:    finding.Source,
+			Sources:   []string{finding.Source},
+			Tenant:    tenant,
+			Status:    result.Status,
+		})
+	}
+	return data
+}
@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/resolve/resolvetest"
+)
+
+func TestTemplateUsesIPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     bool
+	}{
+		{"field", "{{.Domain}} {{.IPs}}", true},
+		{"function argument", `{{join .IPs ","}}`, true},
+		{"range", "{{range .IPs}}{{.}} {{end}}", true},
+		{"condition", "{{if .IPs}}{{.Domain}}{{end}}", true},
+		{"root variable", "{{with .Tenant}}{{$.IPs}}{{end}}", true},
+		{"defined template", `{{define "ips"}}{{.IPs}}{{end}}{{template "ips" .}}`, true},
+		{"without addresses", "{{.Domain}},{{.Tenant.Name}}", false},
+		{"in text only", "IPs {{.Domain}}", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := parseOutputTemplate(test.template, "")
+			if err != nil {
+				t.Fatalf("could not parse template: %s", err)
+			}
+			if got := templateUsesIPs(tmpl); got != test.want {
+				t.Errorf("templateUsesIPs(%q) = %v, want %v", test.template, got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveIPs(t *testing.T) {
+	resolver := resolvetest.NewResolver(t, resolvetest.Records{dns.TypeA: {
+		"contoso.com":     {"192.0.2.2", "192.0.2.1"},
+		"www.contoso.com": {"192.0.2.3"},
+	}})
+	hosts := map[string]resolve.HostEntry{
+		"contoso.com":             {Host: "contoso.com"},
+		"www.contoso.com":         {Host: "www.contoso.com"},
+		"contoso.onmicrosoft.com": {Host: "contoso.onmicrosoft.com"},
+	}
+
+	got := resolveIPs(context.Background(), resolver, hosts)
+	want := map[string][]string{
+		"contoso.com":     {"192.0.2.1", "192.0.2.2"},
+		"www.contoso.com": {"192.0.2.3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveIPs() = %v, want %v", got, want)
+	}
+
+	result := diffResult("contoso.com", statusOK, []string{"contoso.com", "contoso.onmicrosoft.com"})
+	result.IPs = got
+	for _, data := range templateResults(result) {
+		if !reflect.DeepEqual(data.IPs, want[data.Domain]) {
+			t.Errorf("IPs of %s = %v, want %v", data.Domain, data.IPs, want[data.Domain])
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package runner
 
 import (
+	"sort"
 	"strings"
 
+	"github.com/upmux/tenantfinder/pkg/source"
+
 	"github.com/pkg/errors"
 	stringsutil "github.com/projectdiscovery/utils/strings"
 )
@@ -28,3 +31,65 @@ func preprocessDomain(s string) string {
 		},
 	)
 }
+
+// Status of an enumerated input
+const (
+	statusOK          = "ok"
+	statusNotInTenant = "not_in_tenant"
+	statusThrottled   = "throttled"
+	statusError       = "error"
+)
+
+// inputStatus returns the status of an input from the outcome of its enumeration
+func inputStatus(hosts int, noTenant, throttled bool, errs int) string {
+	switch {
+	case hosts > 0:
+		return statusOK
+	case throttled:
+		return statusThrottled
+	case errs > 0:
+		return statusError
+	case noTenant:
+		return statusNotInTenant
+	}
+	return statusOK
+}
+
+// tenantName returns the initial <name>.onmicrosoft.com domain of the tenant
+// found for an input, falling back to the input itself
+func tenantName[V any](input string, hosts map[string]V) string {
+	var name string
+	for host := range hosts {
+		if !strings.HasSuffix(host, ".onmicrosoft.com") || strings.Count(host, ".") != 2 {
+			continue
+		}
+		if name == "" || host < name {
+			name = host
+		}
+	}
+	if name == "" {
+		return input
+	}
+	return name
+}
+
+// findingKey identifies a finding returned by a source, so it is kept once
+type findingKey struct {
+	Type      source.ResultType
+	Source    string
+	Value     string
+	Reference string
+}
+
+// sortFindings orders the findings of an input by type, value and source
+func sortFindings(findings []source.Result) {
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Type != findings[j].Type {
+			return findings[i].Type < findings[j].Type
+		}
+		if findings[i].Value != findings[j].Value {
+			return findings[i].Value < findings[j].Value
+		}
+		return findings[i].Source < findings[j].Source
+	})
+}
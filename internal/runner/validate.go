@@ -4,8 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
 
 	"github.com/upmux/tenantfinder/pkg/agent"
+	"github.com/upmux/tenantfinder/pkg/session"
 
 	"github.com/projectdiscovery/gologger"
 	"github.com/projectdiscovery/gologger/formatter"
@@ -18,7 +22,7 @@ import (
 func (options *Options) validateOptions() error {
 	// Check if domain, list of domains, or stdin info was provided.
 	// If none was provided, then return.
-	if len(options.Domain) == 0 && !options.Stdin {
+	if len(options.Domain) == 0 && !options.Stdin && options.Mode != ModeServe {
 		return errors.New("no input list provided")
 	}
 
@@ -31,6 +35,79 @@ func (options *Options) validateOptions() error {
 		return errors.New("timeout cannot be zero")
 	}
 
+	if options.Retries < 0 {
+		return errors.New("retries cannot be negative")
+	}
+
+	switch options.ProxyRotation {
+	case session.ProxyRotationRoundRobin, session.ProxyRotationRandom:
+	default:
+		return fmt.Errorf("invalid proxy rotation %s", options.ProxyRotation)
+	}
+
+	if options.RateLimitPerProxy && options.Proxy == "" && options.ProxyList == "" {
+		return errors.New("rate limit per proxy requires a proxy or proxy list")
+	}
+
+	if options.Replay != "" && (options.Proxy != "" || options.ProxyList != "") {
+		return errors.New("replay cannot be used with proxies")
+	}
+
+	if options.Insecure && options.CACert != "" {
+		return errors.New("both insecure and ca certificate specified")
+	}
+
+	if (options.ClientCert == "") != (options.ClientKey == "") {
+		return errors.New("client certificate and key must be specified together")
+	}
+
+	if options.Report != "" && reportFormat(options.Report) == "" {
+		return errors.New("report file must have a .html or .md extension")
+	}
+
+	if options.Template != "" && options.TemplateFile != "" {
+		return errors.New("both template and template file specified")
+	}
+
+	if options.Diff != "" && (options.Template != "" || options.TemplateFile != "") {
+		return errors.New("diff mode cannot be used with an output template")
+	}
+
+	switch options.WebhookFormat {
+	case webhookFormatJSON, webhookFormatSlack, webhookFormatTeams:
+	default:
+		return fmt.Errorf("invalid webhook format %s", options.WebhookFormat)
+	}
+
+	if options.WebhookBatchSize <= 0 {
+		return errors.New("webhook batch size must be greater than zero")
+	}
+
+	if options.WebhookRetries < 0 {
+		return errors.New("webhook retries cannot be negative")
+	}
+
+	if options.Mode == ModeMonitor {
+		if options.MonitorCron != "" {
+			if _, err := cron.ParseStandard(options.MonitorCron); err != nil {
+				return fmt.Errorf("invalid cron expression %s: %s", options.MonitorCron, err)
+			}
+		} else if options.MonitorInterval < time.Second {
+			return errors.New("monitor interval must be at least one second")
+		}
+	}
+
+	if options.Mode == ModeServe && options.ServerWorkers <= 0 {
+		return errors.New("server workers must be greater than zero")
+	}
+
+	// The long running modes only hand their results to the sinks that outlive a run
+	if options.Mode == ModeMonitor || options.Mode == ModeServe {
+		if flag := runOutputFlag(options); flag != "" {
+			return fmt.Errorf("%s cannot be used in %s mode", flag, options.Mode)
+		}
+	}
+
 	sources := mapsutil.GetKeys(agent.AllSources)
 	for source := range options.RateLimits.AsMap() {
 		if !sliceutil.Contains(sources, source) {
@@ -39,6 +116,30 @@ func (options *Options) validateOptions() error {
 	}
 	return nil
 }
+
+// runOutputFlag returns the first flag set among the outputs written at the
+// end of a single enumeration, which the long running modes never reach
+func runOutputFlag(options *Options) string {
+	for _, output := range []struct {
+		flag  string
+		value string
+	}{
+		{"-output", options.OutputFile},
+		{"-output-dir", options.OutputDirectory},
+		{"-report", options.Report},
+		{"-opengraph", options.OpenGraph},
+		{"-template", options.Template},
+		{"-template-file", options.TemplateFile},
+		{"-diff", options.Diff},
+		{"-failed-output", options.FailedOutput},
+	} {
+		if output.value != "" {
+			return output.flag
+		}
+	}
+	return ""
+}
+
 func stripRegexString(val string) string {
 	val = strings.ReplaceAll(val, ".", "\\.")
 	val = strings.ReplaceAll(val, "*", ".*")
@@ -0,0 +1,250 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// Payload formats supported by the webhook sink
+const (
+	webhookFormatJSON  = "json"
+	webhookFormatSlack = "slack"
+	webhookFormatTeams = "teams"
+)
+
+// webhookSignatureHeader carries the hex encoded HMAC-SHA256 of the request body
+const webhookSignatureHeader = "X-Tenantfinder-Signature"
+
+// webhookMaxBatches is the number of batches kept queued while the webhook
+// fails, the oldest results being dropped beyond that
+const webhookMaxBatches = 10
+
+// webhookShutdownTimeout bounds the last flush of the long running modes
+const webhookShutdownTimeout = 30 * time.Second
+
+// webhookClient posts json payloads to a url with custom headers,
+// an optional HMAC signature and retries on transient failures
+type webhookClient struct {
+	url     string
+	headers map[string]string
+	secret  string
+	retries int
+	client  *http.Client
+}
+
+// webhookSink posts the results of every input to a webhook in batches
+type webhookSink struct {
+	mutex     sync.Mutex
+	client    *webhookClient
+	format    string
+	batchSize int
+	pending   []webhookResult
+	// dropped counts the results dropped from the queue while the webhook failed
+	dropped int
+}
+
+// webhookResult is a domain or finding posted to the webhook, findings
+// having a type and value instead of a domain
+type webhookResult struct {
+	Domain    string `json:"domain,omitempty"`
+	Input     string `json:"input"`
+	Source    string `json:"source"`
+	Type      string `json:"type,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+func newWebhookClient(url string, options *Options) *webhookClient {
+	headers := make(map[string]string)
+	for _, header := range options.WebhookHeaders {
+		key, value, _ := strings.Cut(header, ":")
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return &webhookClient{
+		url:     url,
+		headers: headers,
+		secret:  options.WebhookSecret,
+		retries: options.WebhookRetries,
+		client:  &http.Client{Timeout: time.Duration(options.Timeout) * time.Second},
+	}
+}
+
+// send posts the payload, retrying with an increasing delay on network
+// errors, throttling and server errors
+func (c *webhookClient) send(ctx context.Context, payload []byte) error {
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			delay := time.Duration(1<<(attempt-1)) * time.Second
+			gologger.Debug().Msgf("Retrying webhook %s in %s: %s\n", c.url, delay, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		var retry bool
+		retry, err = c.post(ctx, payload)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *webhookClient) post(ctx context.Context, payload []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	if c.secret != "" {
+		mac := hmac.New(sha256.New, []byte(c.secret))
+		mac.Write(payload)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected status code %d received from %s", resp.StatusCode, c.url)
+	}
+	return false, nil
+}
+
+func newWebhookSink(options *Options) *webhookSink {
+	return &webhookSink{
+		client:    newWebhookClient(options.Webhook, options),
+		format:    options.WebhookFormat,
+		batchSize: options.WebhookBatchSize,
+	}
+}
+
+// add queues the domains and findings of an input and sends every full batch.
+// Results whose batch failed stay queued for the next send.
+func (w *webhookSink) add(ctx context.Context, result *inputResult) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	hosts := make([]string, 0, len(result.Hosts))
+	for host := range result.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	entries := make([]webhookResult, 0, len(hosts)+len(result.Findings))
+	for _, host := range hosts {
+		entries = append(entries, webhookResult{
+			Domain: host,
+			Input:  result.Input,
+			Source: result.Hosts[host].Source,
+			Status: result.Status,
+		})
+	}
+	for _, finding := range result.Findings {
+		entries = append(entries, webhookResult{
+			Input:     result.Input,
+			Source:    finding.Source,
+			Type:      finding.Type.String(),
+			Value:     finding.Value,
+			Reference: finding.Reference,
+			Status:    result.Status,
+		})
+	}
+
+	w.pending = append(w.pending, entries...)
+	if limit := w.batchSize * webhookMaxBatches; len(w.pending) > limit {
+		dropped := len(w.pending) - limit
+		w.pending = append(w.pending[:0], w.pending[dropped:]...)
+		w.dropped += dropped
+		gologger.Warning().Msgf("Dropped %d results queued for %s as it keeps failing (%d in total)\n", dropped, w.client.url, w.dropped)
+	}
+	return w.sendLocked(ctx, false)
+}
+
+// flush sends every queued result
+func (w *webhookSink) flush(ctx context.Context) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.sendLocked(ctx, true)
+}
+
+// sendLocked sends the queued results batch by batch, keeping the last
+// partial batch queued unless all is set. It stops at the first failed
+// batch, which stays queued.
+func (w *webhookSink) sendLocked(ctx context.Context, all bool) error {
+	for len(w.pending) >= w.batchSize || (all && len(w.pending) > 0) {
+		size := min(w.batchSize, len(w.pending))
+		payload, err := webhookPayload(w.format, w.pending[:size])
+		if err != nil {
+			return err
+		}
+		if err := w.client.send(ctx, payload); err != nil {
+			return err
+		}
+		w.pending = append(w.pending[:0], w.pending[size:]...)
+	}
+	return nil
+}
+
+// webhookPayload renders a batch of results in the given format
+func webhookPayload(format string, results []webhookResult) ([]byte, error) {
+	switch format {
+	case webhookFormatSlack:
+		return jsoniter.Marshal(map[string]string{
+			"text": webhookText(results, "*%s* (%s via %s)", "%s *%s* (%s via %s)"),
+		})
+	case webhookFormatTeams:
+		return jsoniter.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  fmt.Sprintf("tenantfinder found %d results", len(results)),
+			"text":     strings.ReplaceAll(webhookText(results, "**%s** (%s via %s)", "%s **%s** (%s via %s)"), "\n", "<br>"),
+		})
+	default:
+		return jsoniter.Marshal(map[string]interface{}{
+			"results": results,
+		})
+	}
+}
+
+// webhookText renders a line per domain and finding, formatting the domains
+// with their name, input and source and the findings with their type first
+func webhookText(results []webhookResult, domainLine, findingLine string) string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "tenantfinder found %d results:", len(results))
+	for _, result := range results {
+		sb.WriteString("\n")
+		if result.Domain != "" {
+			fmt.Fprintf(sb, domainLine, result.Domain, result.Input, result.Source)
+		} else {
+			fmt.Fprintf(sb, findingLine, result.Type, result.Value, result.Input, result.Source)
+		}
+	}
+	return sb.String()
+}
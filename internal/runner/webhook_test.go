@@ -0,0 +1,242 @@
+package runner
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// webhookServer records the requests posted to it and answers them with
+// the given status codes in turn, then with 200
+type webhookServer struct {
+	mutex    sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bodies = append(s.bodies, body)
+	s.headers = append(s.headers, req.Header.Clone())
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// batches returns the number of results posted in every request
+func (s *webhookServer) batches(t *testing.T) []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var sizes []int
+	for _, body := range s.bodies {
+		var payload struct {
+			Results []webhookResult `json:"results"`
+		}
+		if err := jsoniter.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("could not decode webhook payload %s: %s", body, err)
+		}
+		sizes = append(sizes, len(payload.Results))
+	}
+	return sizes
+}
+
+func newTestWebhookSink(url string, batchSize, retries int, secret string) *webhookSink {
+	return newWebhookSink(&Options{
+		Webhook:          url,
+		WebhookFormat:    webhookFormatJSON,
+		WebhookBatchSize: batchSize,
+		WebhookRetries:   retries,
+		WebhookSecret:    secret,
+		Timeout:          5,
+	})
+}
+
+func testInputResult(input string, hosts int, findings int) *inputResult {
+	result := &inputResult{Input: input, Status: statusOK, Hosts: make(map[string]resolve.HostEntry)}
+	for i := 0; i < hosts; i++ {
+		host := fmt.Sprintf("host%d.%s", i, input)
+		result.Hosts[host] = resolve.HostEntry{Domain: input, Host: host, Source: "aad"}
+	}
+	for i := 0; i < findings; i++ {
+		result.Findings = append(result.Findings, source.Result{Source: "autodiscover", Type: source.Endpoint, Value: fmt.Sprintf("https://%s/%d", input, i)})
+	}
+	return result
+}
+
+func TestWebhookSinkBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		hosts     int
+		findings  int
+		added     []int
+		flushed   []int
+	}{
+		{name: "partial batch waits for flush", batchSize: 10, hosts: 3, added: nil, flushed: []int{3}},
+		{name: "full batches sent on add", batchSize: 2, hosts: 5, added: []int{2, 2}, flushed: []int{2, 2, 1}},
+		{name: "findings are batched with domains", batchSize: 3, hosts: 2, findings: 4, added: []int{3, 3}, flushed: []int{3, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &webhookServer{}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+
+			sink := newTestWebhookSink(httpServer.URL, test.batchSize, 0, "")
+			if err := sink.add(context.Background(), testInputResult("contoso.com", test.hosts, test.findings)); err != nil {
+				t.Fatalf("add failed: %s", err)
+			}
+			if got := server.batches(t); fmt.Sprint(got) != fmt.Sprint(test.added) {
+				t.Errorf("batches after add = %v, want %v", got, test.added)
+			}
+			if err := sink.flush(context.Background()); err != nil {
+				t.Fatalf("flush failed: %s", err)
+			}
+			if got := server.batches(t); fmt.Sprint(got) != fmt.Sprint(test.flushed) {
+				t.Errorf("batches after flush = %v, want %v", got, test.flushed)
+			}
+		})
+	}
+}
+
+func TestWebhookSinkKeepsFailedBatch(t *testing.T) {
+	server := &webhookServer{statuses: []int{http.StatusBadRequest}}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	sink := newTestWebhookSink(httpServer.URL, 2, 0, "")
+	if err := sink.add(context.Background(), testInputResult("contoso.com", 3, 0)); err == nil {
+		t.Fatal("add succeeded, want the error of the failed batch")
+	}
+	if err := sink.add(context.Background(), testInputResult("fabrikam.com", 1, 0)); err != nil {
+		t.Fatalf("add failed: %s", err)
+	}
+	if err := sink.flush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %s", err)
+	}
+
+	// The failed batch is sent again along with every result queued after it
+	if got, want := server.batches(t), []int{2, 2, 2}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestWebhookClientSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{name: "unsigned"},
+		{name: "signed", secret: "s3cr3t"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &webhookServer{}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+
+			client := newWebhookClient(httpServer.URL, &Options{WebhookSecret: test.secret, Timeout: 5})
+			payload := []byte(`{"results":[]}`)
+			if err := client.send(context.Background(), payload); err != nil {
+				t.Fatalf("send failed: %s", err)
+			}
+
+			want := ""
+			if test.secret != "" {
+				mac := hmac.New(sha256.New, []byte(test.secret))
+				mac.Write(payload)
+				want = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			}
+			if got := server.headers[0].Get(webhookSignatureHeader); got != want {
+				t.Errorf("signature = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWebhookClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int
+		wantErr  bool
+	}{
+		{name: "server error is retried", statuses: []int{http.StatusServiceUnavailable}, retries: 1, requests: 2},
+		{name: "throttling is retried", statuses: []int{http.StatusTooManyRequests}, retries: 1, requests: 2},
+		{name: "client error is not retried", statuses: []int{http.StatusBadRequest}, retries: 1, requests: 1, wantErr: true},
+		{name: "retries are exhausted", statuses: []int{http.StatusBadGateway, http.StatusBadGateway}, retries: 1, requests: 2, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &webhookServer{statuses: test.statuses}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+
+			client := newWebhookClient(httpServer.URL, &Options{WebhookRetries: test.retries, Timeout: 5})
+			err := client.send(context.Background(), []byte(`{}`))
+			if (err != nil) != test.wantErr {
+				t.Errorf("send error = %v, want error %t", err, test.wantErr)
+			}
+			if len(server.bodies) != test.requests {
+				t.Errorf("requests = %d, want %d", len(server.bodies), test.requests)
+			}
+		})
+	}
+}
+
+func TestEventWriterWebhookFormat(t *testing.T) {
+	event := monitorEvent{Type: eventDomainAdded, Input: "contoso.com", Tenant: "contoso.onmicrosoft.com", Domain: "fabrikam.com"}
+	tests := []struct {
+		format string
+		field  string
+		want   string
+	}{
+		{format: webhookFormatJSON, field: "domain", want: "fabrikam.com"},
+		{format: webhookFormatSlack, field: "text", want: "tenantfinder: [domain_added] contoso.onmicrosoft.com fabrikam.com"},
+		{format: webhookFormatTeams, field: "text", want: "tenantfinder: [domain_added] contoso.onmicrosoft.com fabrikam.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			server := &webhookServer{}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+
+			events, err := newEventWriter(&Options{EventsWebhook: httpServer.URL, WebhookFormat: test.format, Output: io.Discard, Timeout: 5})
+			if err != nil {
+				t.Fatalf("could not create event writer: %s", err)
+			}
+			defer events.close()
+			if err := events.write(context.Background(), event); err != nil {
+				t.Fatalf("could not write event: %s", err)
+			}
+
+			var payload map[string]string
+			if err := jsoniter.Unmarshal(server.bodies[0], &payload); err != nil {
+				t.Fatalf("could not decode payload %s: %s", server.bodies[0], err)
+			}
+			if got := payload[test.field]; got != test.want {
+				t.Errorf("%s = %q, want %q", test.field, got, test.want)
+			}
+		})
+	}
+}
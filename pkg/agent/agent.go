@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math"
 	"slices"
@@ -12,8 +13,10 @@ import (
 
 	"golang.org/x/exp/maps"
 
+	"github.com/upmux/tenantfinder/pkg/resolve"
 	"github.com/upmux/tenantfinder/pkg/session"
 	"github.com/upmux/tenantfinder/pkg/source"
+	"github.com/upmux/tenantfinder/pkg/source/idp"
 
 	"github.com/projectdiscovery/ratelimit"
 	mapsutil "github.com/projectdiscovery/utils/maps"
@@ -26,6 +29,12 @@ import (
 // a layer to build upon.
 type Agent struct {
 	sources []source.Source
+	// throttle outlives the enumerations so throttled sources stay slowed down across inputs
+	throttle *session.Throttle
+	// resolver is created on first use, as only some sources query dns
+	resolverOnce sync.Once
+	resolver     *resolve.Resolver
+	resolverErr  error
 }
 
 // New creates a new agent for domain discovery
@@ -71,7 +80,7 @@ func New(sourceNames, excludedSourceNames []string, useAllSources bool) *Agent {
 	}
 
 	// Create the agent, insert the sources and remove the excluded sources
-	agent := &Agent{sources: maps.Values(sources)}
+	agent := &Agent{sources: maps.Values(sources), throttle: session.NewThrottle()}
 
 	return agent
 }
@@ -90,6 +99,14 @@ type CustomRateLimit struct {
 
 type EnumerationOptions struct {
 	customRateLimiter *CustomRateLimit
+	multiRateLimiter  *ratelimit.MultiLimiter
+	retries           int
+	proxyPool         *session.ProxyPool
+	tlsConfig         *tls.Config
+	harRecorder       *session.HARRecorder
+	harReplay         *session.HARReplay
+	noVerifyHosts     bool
+	authentication    bool
 }
 
 type EnumerateOption func(opts *EnumerationOptions)
@@ -100,6 +117,87 @@ func WithCustomRateLimit(crl *CustomRateLimit) EnumerateOption {
 	}
 }
 
+// WithMultiRateLimiter makes the enumeration share an existing rate limiter
+// instead of building one for the query
+func WithMultiRateLimiter(mrl *ratelimit.MultiLimiter) EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.multiRateLimiter = mrl
+	}
+}
+
+// WithRetries retries failed idempotent source requests the given number of times
+func WithRetries(retries int) EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.retries = retries
+	}
+}
+
+// WithProxyPool rotates the source requests over the proxies of the pool
+func WithProxyPool(pool *session.ProxyPool) EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.proxyPool = pool
+	}
+}
+
+// WithTLSConfig replaces the default tls configuration of the source requests
+func WithTLSConfig(config *tls.Config) EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.tlsConfig = config
+	}
+}
+
+// WithHARRecorder records the source requests and responses
+func WithHARRecorder(recorder *session.HARRecorder) EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.harRecorder = recorder
+	}
+}
+
+// WithHARReplay serves the source requests from recorded responses instead of the network
+func WithHARReplay(replay *session.HARReplay) EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.harReplay = replay
+	}
+}
+
+// WithoutHostVerification makes the sources return the hostnames they derive without checking they resolve
+func WithoutHostVerification() EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.noVerifyHosts = true
+	}
+}
+
+// WithAuthentication looks up whether the input signs in with Entra ID or a
+// federated identity provider along with the sources
+func WithAuthentication() EnumerateOption {
+	return func(opts *EnumerationOptions) {
+		opts.authentication = true
+	}
+}
+
+// Resolver returns the dns resolver the sessions of the agent query with,
+// created on first use
+func (a *Agent) Resolver() (*resolve.Resolver, error) {
+	a.resolverOnce.Do(func() {
+		a.resolver, a.resolverErr = resolve.NewClient(nil)
+		if a.resolverErr != nil {
+			gologger.Error().Msgf("Could not create dns resolver: %s\n", a.resolverErr)
+		}
+	})
+	return a.resolver, a.resolverErr
+}
+
+// needsResolver reports whether one of the sources of the agent queries dns
+// through the session
+func (a *Agent) needsResolver(sess *session.Session) bool {
+	for _, currentSource := range a.sources {
+		if resolving, ok := currentSource.(source.Resolving); ok && resolving.NeedsResolver(sess) {
+			return true
+		}
+	}
+	return false
+}
+
 // EnumerateDomains wraps EnumerateDomainsWithCtx with an empty context
 func (a *Agent) EnumerateDomains(query string, proxy string, rateLimit int, timeout int, maxEnumTime time.Duration, options ...EnumerateOption) chan source.Result {
 	return a.EnumerateDomainsWithCtx(context.Background(), query, proxy, rateLimit, timeout, maxEnumTime, options...)
@@ -117,14 +215,35 @@ func (a *Agent) EnumerateDomainsWithCtx(ctx context.Context, query string, proxy
 			enumerateOption(&enumerateOptions)
 		}
 
-		multiRateLimiter, err := a.buildMultiRateLimiter(ctx, rateLimit, enumerateOptions.customRateLimiter)
-		if err != nil {
-			results <- source.Result{
-				Type: source.Error, Error: fmt.Errorf("could not init multi rate limiter for %s: %s", query, err),
+		multiRateLimiter := enumerateOptions.multiRateLimiter
+		if multiRateLimiter == nil {
+			var err error
+			multiRateLimiter, err = a.BuildMultiRateLimiter(ctx, rateLimit, enumerateOptions.customRateLimiter, enumerateOptions.proxyPool)
+			if err != nil {
+				results <- source.Result{
+					Type: source.Error, Error: fmt.Errorf("could not init multi rate limiter for %s: %s", query, err),
+				}
+				return
 			}
-			return
 		}
 		sess := session.NewSession(query, proxy, multiRateLimiter, timeout)
+		sess.Throttle = a.throttle
+		sess.Retries = enumerateOptions.retries
+		sess.ProxyPool = enumerateOptions.proxyPool
+		sess.NoVerifyHosts = enumerateOptions.noVerifyHosts
+		// The sources querying dns report the missing resolver themselves
+		if a.needsResolver(sess) {
+			sess.Resolver, _ = a.Resolver()
+		}
+		if enumerateOptions.tlsConfig != nil {
+			sess.SetTLSConfig(enumerateOptions.tlsConfig)
+		}
+		if enumerateOptions.harReplay != nil {
+			sess.SetHARReplay(enumerateOptions.harReplay)
+		}
+		if enumerateOptions.harRecorder != nil {
+			sess.SetHARRecorder(enumerateOptions.harRecorder)
+		}
 
 		ctx, cancel := context.WithTimeout(ctx, maxEnumTime)
 
@@ -140,34 +259,81 @@ func (a *Agent) EnumerateDomainsWithCtx(ctx context.Context, query string, proxy
 				wg.Done()
 			}(runner)
 		}
+		if enumerateOptions.authentication {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				authentication, signIn, err := idp.Authentication(ctx, sess, query)
+				if err != nil {
+					gologger.Debug().Msgf("Could not look up the authentication of %s: %s\n", query, err)
+					return
+				}
+				results <- source.Result{Source: idp.SourceName, Type: source.Authentication, Value: authentication, Reference: signIn}
+			}()
+		}
 		wg.Wait()
 		cancel()
 	}()
 	return results
 }
 
-func (a *Agent) buildMultiRateLimiter(ctx context.Context, globalRateLimit int, rateLimit *CustomRateLimit) (*ratelimit.MultiLimiter, error) {
+// BuildMultiRateLimiter creates a rate limiter with a limit for each source of the agent,
+// or for each source and proxy when the limits of the proxy pool apply per proxy
+func (a *Agent) BuildMultiRateLimiter(ctx context.Context, globalRateLimit int, rateLimit *CustomRateLimit, proxyPool *session.ProxyPool) (*ratelimit.MultiLimiter, error) {
 	var multiRateLimiter *ratelimit.MultiLimiter
 	var err error
-	for _, source := range a.sources {
+	for _, name := range a.rateLimitedNames() {
 		var rl uint
-		if sourceRateLimit, ok := rateLimit.Custom.Get((source.Name())); ok {
+		if sourceRateLimit, ok := rateLimit.Custom.Get(name); ok {
 			rl = sourceRateLimitOrDefault(uint(globalRateLimit), sourceRateLimit)
 		}
 
-		if rl > 0 {
-			multiRateLimiter, err = addRateLimiter(ctx, multiRateLimiter, source.Name(), rl, time.Second)
-		} else {
-			multiRateLimiter, err = addRateLimiter(ctx, multiRateLimiter, source.Name(), math.MaxUint32, time.Millisecond)
+		keys := []string{name}
+		if proxyPool != nil && proxyPool.PerProxyRateLimit {
+			keys = keys[:0]
+			for _, proxyURL := range proxyPool.Proxies() {
+				keys = append(keys, proxyPool.RateLimitKey(name, proxyURL))
+			}
 		}
 
-		if err != nil {
-			break
+		for _, key := range keys {
+			if rl > 0 {
+				multiRateLimiter, err = addRateLimiter(ctx, multiRateLimiter, key, rl, time.Second)
+			} else {
+				multiRateLimiter, err = addRateLimiter(ctx, multiRateLimiter, key, math.MaxUint32, time.Millisecond)
+			}
+			if err != nil {
+				return multiRateLimiter, err
+			}
 		}
 	}
 	return multiRateLimiter, err
 }
 
+// rateLimitedNames returns the names of the sources of the agent along with
+// the sources whose shared lookups they run, which need a limit of their own
+// even when they are not selected. The authentication lookup runs as idp.
+func (a *Agent) rateLimitedNames() []string {
+	var names []string
+	seen := make(map[string]struct{})
+	add := func(name string) {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	add(idp.SourceName)
+	for _, currentSource := range a.sources {
+		add(currentSource.Name())
+		if dependent, ok := currentSource.(source.Dependent); ok {
+			for _, dependency := range dependent.Dependencies() {
+				add(dependency)
+			}
+		}
+	}
+	return names
+}
+
 func sourceRateLimitOrDefault(defaultRateLimit uint, sourceRateLimit uint) uint {
 	if sourceRateLimit > 0 {
 		return sourceRateLimit
@@ -201,7 +367,9 @@ func (a *Agent) GetStatistics() map[string]source.Statistics {
 	})
 
 	for _, source := range a.sources {
-		stats[source.Name()] = source.Statistics()
+		stat := source.Statistics()
+		stat.Throttled = a.throttle.TakeEvents(source.Name())
+		stats[source.Name()] = stat
 	}
 	return stats
 }
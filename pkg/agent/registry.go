@@ -3,12 +3,30 @@ package agent
 import (
 	"github.com/upmux/tenantfinder/pkg/source"
 	"github.com/upmux/tenantfinder/pkg/source/aad"
+	"github.com/upmux/tenantfinder/pkg/source/adfsmetadata"
+	"github.com/upmux/tenantfinder/pkg/source/autodiscover"
+	"github.com/upmux/tenantfinder/pkg/source/crtsh"
+	"github.com/upmux/tenantfinder/pkg/source/dnsfingerprint"
+	"github.com/upmux/tenantfinder/pkg/source/exchangeonprem"
+	"github.com/upmux/tenantfinder/pkg/source/google"
+	"github.com/upmux/tenantfinder/pkg/source/idp"
+	"github.com/upmux/tenantfinder/pkg/source/mailcorrelation"
+	"github.com/upmux/tenantfinder/pkg/source/tenanthosts"
 
 	mapsutil "github.com/projectdiscovery/utils/maps"
 )
 
 var AllSources = map[string]source.Source{
-	"aad": &aad.Source{},
+	"aad":              &aad.Source{},
+	"adfs-metadata":    &adfsmetadata.Source{},
+	"autodiscover":     &autodiscover.Source{},
+	"crtsh":            &crtsh.Source{},
+	"dns":              &dnsfingerprint.Source{},
+	"exchange-onprem":  &exchangeonprem.Source{},
+	"google":           &google.Source{},
+	"idp":              &idp.Source{},
+	"mail-correlation": &mailcorrelation.Source{},
+	"tenant-hosts":     &tenanthosts.Source{},
 }
 
 var sourceWarnings = mapsutil.NewSyncLockMap[string, string](
@@ -0,0 +1,3 @@
+// Package emailsec looks up the email security posture of a domain: its
+// spf, dmarc, mta-sts and tls-rpt records and the Microsoft 365 dkim selectors.
+package emailsec
@@ -0,0 +1,370 @@
+package emailsec
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+)
+
+const (
+	// maxSPFLookups is the number of dns lookups an spf evaluation may need (RFC 7208)
+	maxSPFLookups = 10
+	// maxSPFDepth stops following includes nested deeper than any sane record
+	maxSPFDepth = 10
+)
+
+// dkimSelectors are the selectors Exchange Online signs with
+var dkimSelectors = []string{"selector1", "selector2"}
+
+// Posture is the email security posture of a domain
+type Posture struct {
+	SPF    *SPF    `json:"spf,omitempty"`
+	DMARC  *DMARC  `json:"dmarc,omitempty"`
+	MTASTS *MTASTS `json:"mta_sts,omitempty"`
+	TLSRPT *TLSRPT `json:"tls_rpt,omitempty"`
+	DKIM   []DKIM  `json:"dkim,omitempty"`
+	// Spoofable is set when no dmarc policy is enforced for the domain
+	Spoofable bool `json:"spoofable"`
+}
+
+// SPF is the spf record of a domain with its include chain flattened
+type SPF struct {
+	Record string `json:"record"`
+	// All is the qualified all mechanism ending the evaluation, such as -all or ~all
+	All string `json:"all,omitempty"`
+	// Includes lists the domains included directly or through other includes
+	Includes []string `json:"includes,omitempty"`
+	// Lookups is the number of dns lookups the evaluation needs
+	Lookups int      `json:"lookups"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// DMARC is the dmarc policy applying to a domain
+type DMARC struct {
+	Record          string `json:"record"`
+	Policy          string `json:"policy"`
+	SubdomainPolicy string `json:"subdomain_policy,omitempty"`
+	Percentage      int    `json:"pct"`
+	// Domain is the organizational domain the policy was inherited from
+	Domain string   `json:"domain,omitempty"`
+	RUA    []string `json:"rua,omitempty"`
+	RUF    []string `json:"ruf,omitempty"`
+}
+
+// MTASTS is the mta-sts record and policy of a domain
+type MTASTS struct {
+	Record string   `json:"record"`
+	ID     string   `json:"id,omitempty"`
+	Mode   string   `json:"mode,omitempty"`
+	MX     []string `json:"mx,omitempty"`
+	MaxAge int      `json:"max_age,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// TLSRPT is the smtp tls reporting record of a domain
+type TLSRPT struct {
+	Record string   `json:"record"`
+	RUA    []string `json:"rua,omitempty"`
+}
+
+// DKIM is a Microsoft 365 dkim selector published by a domain
+type DKIM struct {
+	Selector string `json:"selector"`
+	Target   string `json:"target"`
+}
+
+// Fetcher fetches the mta-sts policies, such as a session
+type Fetcher interface {
+	SimpleGet(ctx context.Context, url string) (*http.Response, error)
+}
+
+// Checker looks up the email security posture of domains
+type Checker struct {
+	resolver *resolve.Resolver
+	// fetcher fetches the mta-sts policies
+	fetcher Fetcher
+}
+
+// NewChecker creates a checker querying dns with the resolver and fetching
+// the mta-sts policies with the fetcher, which only Check needs
+func NewChecker(resolver *resolve.Resolver, fetcher Fetcher) *Checker {
+	return &Checker{resolver: resolver, fetcher: fetcher}
+}
+
+// Check returns the email security posture of a domain
+func (c *Checker) Check(ctx context.Context, domain string) *Posture {
+	posture := &Posture{
+		SPF:    c.SPF(domain),
+		DMARC:  c.DMARC(domain),
+		MTASTS: c.mtaSTS(ctx, domain),
+		TLSRPT: c.tlsRPT(domain),
+		DKIM:   c.dkim(domain),
+	}
+	posture.Spoofable = !posture.DMARC.enforced()
+	return posture
+}
+
+// record returns the first txt record of a host starting with the version tag
+func (c *Checker) record(host, version string) string {
+	records, err := c.resolver.TXT(host)
+	if err != nil {
+		return ""
+	}
+	for _, record := range records {
+		if strings.HasPrefix(strings.ToLower(record), strings.ToLower(version)) {
+			return record
+		}
+	}
+	return ""
+}
+
+// SPF returns the spf record of a domain with its include chain flattened
+func (c *Checker) SPF(domain string) *SPF {
+	record := c.record(domain, "v=spf1")
+	if record == "" {
+		return nil
+	}
+	spf := &SPF{Record: record}
+	walk := &spfWalk{path: map[string]struct{}{domain: {}}, flattened: make(map[string]flattenedSPF)}
+	spf.All = c.flattenSPF(record, spf, walk, 0)
+	if spf.Lookups > maxSPFLookups {
+		spf.Errors = append(spf.Errors, fmt.Sprintf("%d dns lookups exceed the limit of %d", spf.Lookups, maxSPFLookups))
+	}
+	return spf
+}
+
+// spfWalk tracks the domains met while flattening an spf record
+type spfWalk struct {
+	// path holds the domains being flattened, including one of them again is a loop
+	path map[string]struct{}
+	// flattened holds the domains already flattened through another include,
+	// which an evaluation looks up again but which are not fetched again
+	flattened map[string]flattenedSPF
+}
+
+// flattenedSPF is the outcome of flattening the spf record of an included domain
+type flattenedSPF struct {
+	all     string
+	lookups int
+}
+
+// flattenSPF walks the terms of an spf record, following its includes and
+// redirect, and returns the qualified all mechanism of the record
+func (c *Checker) flattenSPF(record string, spf *SPF, walk *spfWalk, depth int) string {
+	var all, redirect string
+	for _, term := range strings.Fields(strings.ToLower(record))[1:] {
+		if value, ok := strings.CutPrefix(term, "redirect="); ok {
+			redirect = value
+			continue
+		}
+
+		qualifier := "+"
+		if strings.ContainsAny(term[:1], "+-~?") {
+			qualifier, term = term[:1], term[1:]
+		}
+		mechanism, value, _ := strings.Cut(term, ":")
+		mechanism, _, _ = strings.Cut(mechanism, "/")
+
+		switch mechanism {
+		case "all":
+			all = qualifier + "all"
+		case "a", "mx", "ptr", "exists":
+			spf.Lookups++
+		case "include":
+			spf.Lookups++
+			if !slices.Contains(spf.Includes, value) {
+				spf.Includes = append(spf.Includes, value)
+			}
+			c.followSPF(value, spf, walk, depth)
+		}
+	}
+
+	if all == "" && redirect != "" {
+		spf.Lookups++
+		return c.followSPF(redirect, spf, walk, depth)
+	}
+	return all
+}
+
+// followSPF flattens the spf record of an included or redirected domain. A
+// domain included again through another branch counts its lookups again, as
+// the evaluation does, while a domain including itself is reported as a loop.
+func (c *Checker) followSPF(domain string, spf *SPF, walk *spfWalk, depth int) string {
+	if _, ok := walk.path[domain]; ok {
+		spf.Errors = append(spf.Errors, fmt.Sprintf("%s is included in a loop", domain))
+		return ""
+	}
+	if flattened, ok := walk.flattened[domain]; ok {
+		spf.Lookups += flattened.lookups
+		return flattened.all
+	}
+	if depth >= maxSPFDepth {
+		spf.Errors = append(spf.Errors, fmt.Sprintf("%s is nested too deep", domain))
+		return ""
+	}
+
+	record := c.record(domain, "v=spf1")
+	if record == "" {
+		spf.Errors = append(spf.Errors, fmt.Sprintf("%s has no spf record", domain))
+		walk.flattened[domain] = flattenedSPF{}
+		return ""
+	}
+
+	walk.path[domain] = struct{}{}
+	lookups := spf.Lookups
+	all := c.flattenSPF(record, spf, walk, depth+1)
+	delete(walk.path, domain)
+	walk.flattened[domain] = flattenedSPF{all: all, lookups: spf.Lookups - lookups}
+	return all
+}
+
+// DMARC returns the dmarc policy applying to a domain
+func (c *Checker) DMARC(domain string) *DMARC {
+	record := c.record("_dmarc."+domain, "v=DMARC1")
+	var inherited string
+	// Domains without a record fall under the policy of their organizational domain
+	if record == "" {
+		organizational, err := publicsuffix.EffectiveTLDPlusOne(domain)
+		if err != nil || organizational == domain {
+			return nil
+		}
+		if record = c.record("_dmarc."+organizational, "v=DMARC1"); record == "" {
+			return nil
+		}
+		inherited = organizational
+	}
+
+	dmarc := &DMARC{Record: record, Percentage: 100, Domain: inherited}
+	for tag, value := range tags(record) {
+		switch tag {
+		case "p":
+			dmarc.Policy = strings.ToLower(value)
+		case "sp":
+			dmarc.SubdomainPolicy = strings.ToLower(value)
+		case "pct":
+			if percentage, err := strconv.Atoi(value); err == nil {
+				dmarc.Percentage = percentage
+			}
+		case "rua":
+			dmarc.RUA = addresses(value)
+		case "ruf":
+			dmarc.RUF = addresses(value)
+		}
+	}
+	return dmarc
+}
+
+// enforced reports whether the policy rejects or quarantines failing mail
+func (d *DMARC) enforced() bool {
+	if d == nil || d.Percentage == 0 {
+		return false
+	}
+	policy := d.Policy
+	if d.Domain != "" && d.SubdomainPolicy != "" {
+		policy = d.SubdomainPolicy
+	}
+	return policy == "reject" || policy == "quarantine"
+}
+
+func (c *Checker) mtaSTS(ctx context.Context, domain string) *MTASTS {
+	record := c.record("_mta-sts."+domain, "v=STSv1")
+	if record == "" {
+		return nil
+	}
+	mtaSTS := &MTASTS{Record: record, ID: tags(record)["id"]}
+	if err := c.fetchPolicy(ctx, domain, mtaSTS); err != nil {
+		mtaSTS.Error = err.Error()
+	}
+	return mtaSTS
+}
+
+// fetchPolicy reads the mta-sts policy published on the well-known url of the domain
+func (c *Checker) fetchPolicy(ctx context.Context, domain string, mtaSTS *MTASTS) error {
+	response, err := c.fetcher.SimpleGet(ctx, "https://mta-sts."+domain+"/.well-known/mta-sts.txt")
+	if err != nil {
+		if response != nil {
+			response.Body.Close()
+		}
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d for the policy", response.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "mode":
+			mtaSTS.Mode = value
+		case "mx":
+			mtaSTS.MX = append(mtaSTS.MX, value)
+		case "max_age":
+			mtaSTS.MaxAge, _ = strconv.Atoi(value)
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *Checker) tlsRPT(domain string) *TLSRPT {
+	record := c.record("_smtp._tls."+domain, "v=TLSRPTv1")
+	if record == "" {
+		return nil
+	}
+	return &TLSRPT{Record: record, RUA: addresses(tags(record)["rua"])}
+}
+
+func (c *Checker) dkim(domain string) []DKIM {
+	var selectors []DKIM
+	for _, selector := range dkimSelectors {
+		targets, err := c.resolver.CNAME(selector + "._domainkey." + domain)
+		if err != nil || len(targets) == 0 {
+			continue
+		}
+		selectors = append(selectors, DKIM{Selector: selector, Target: strings.TrimSuffix(targets[0], ".")})
+	}
+	return selectors
+}
+
+// tags parses the semicolon separated tag=value list of a record
+func tags(record string) map[string]string {
+	parsed := make(map[string]string)
+	for _, tag := range strings.Split(record, ";") {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			continue
+		}
+		parsed[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return parsed
+}
+
+// addresses splits a comma separated list of reporting uris, dropping the mailto: scheme
+func addresses(value string) []string {
+	var parsed []string
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if mailbox, ok := strings.CutPrefix(strings.ToLower(address), "mailto:"); ok {
+			// A size limit may follow the mailbox, as in mailto:dmarc@example.com!10m
+			address, _, _ = strings.Cut(mailbox, "!")
+		}
+		parsed = append(parsed, address)
+	}
+	return parsed
+}
@@ -0,0 +1,173 @@
+package emailsec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/upmux/tenantfinder/pkg/resolve/resolvetest"
+)
+
+func TestSPF(t *testing.T) {
+	tests := []struct {
+		name    string
+		records map[string]string
+		want    *SPF
+	}{
+		{
+			name:    "no record",
+			records: map[string]string{},
+		},
+		{
+			name: "includes are flattened",
+			records: map[string]string{
+				"contoso.com":                 "v=spf1 mx include:spf.protection.outlook.com -all",
+				"spf.protection.outlook.com":  "v=spf1 ip4:40.92.0.0/15 include:spfd.protection.outlook.com -all",
+				"spfd.protection.outlook.com": "v=spf1 ip4:51.4.72.0/24 -all",
+			},
+			want: &SPF{
+				Record:   "v=spf1 mx include:spf.protection.outlook.com -all",
+				All:      "-all",
+				Includes: []string{"spf.protection.outlook.com", "spfd.protection.outlook.com"},
+				Lookups:  3,
+			},
+		},
+		{
+			name: "redirect provides the all mechanism",
+			records: map[string]string{
+				"contoso.com":      "v=spf1 redirect=_spf.contoso.com",
+				"_spf.contoso.com": "v=spf1 a ~all",
+			},
+			want: &SPF{Record: "v=spf1 redirect=_spf.contoso.com", All: "~all", Lookups: 2},
+		},
+		{
+			name: "diamond include is not an error",
+			records: map[string]string{
+				"contoso.com":        "v=spf1 include:a.contoso.com include:b.contoso.com -all",
+				"a.contoso.com":      "v=spf1 include:shared.contoso.com -all",
+				"b.contoso.com":      "v=spf1 include:shared.contoso.com -all",
+				"shared.contoso.com": "v=spf1 a mx -all",
+			},
+			want: &SPF{
+				Record:   "v=spf1 include:a.contoso.com include:b.contoso.com -all",
+				All:      "-all",
+				Includes: []string{"a.contoso.com", "shared.contoso.com", "b.contoso.com"},
+				Lookups:  8,
+			},
+		},
+		{
+			name: "loop is an error",
+			records: map[string]string{
+				"contoso.com":   "v=spf1 include:a.contoso.com -all",
+				"a.contoso.com": "v=spf1 include:contoso.com -all",
+			},
+			want: &SPF{
+				Record:   "v=spf1 include:a.contoso.com -all",
+				All:      "-all",
+				Includes: []string{"a.contoso.com", "contoso.com"},
+				Lookups:  2,
+				Errors:   []string{"contoso.com is included in a loop"},
+			},
+		},
+		{
+			name: "missing include and too many lookups",
+			records: map[string]string{
+				"contoso.com": "v=spf1 a mx ptr exists:%{i}.contoso.com a:b a:c a:d a:e a:f a:g include:missing.contoso.com ?all",
+			},
+			want: &SPF{
+				Record:   "v=spf1 a mx ptr exists:%{i}.contoso.com a:b a:c a:d a:e a:f a:g include:missing.contoso.com ?all",
+				All:      "?all",
+				Includes: []string{"missing.contoso.com"},
+				Lookups:  11,
+				Errors:   []string{"missing.contoso.com has no spf record", "11 dns lookups exceed the limit of 10"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := resolvetest.NewResolver(t, resolvetest.Records{dns.TypeTXT: txtRecords(test.records)})
+			checker := NewChecker(resolver, nil)
+			if got := checker.SPF("contoso.com"); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("spf = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDMARC(t *testing.T) {
+	tests := []struct {
+		name      string
+		domain    string
+		records   map[string]string
+		want      *DMARC
+		spoofable bool
+	}{
+		{
+			name:      "no record",
+			domain:    "contoso.com",
+			records:   map[string]string{},
+			spoofable: true,
+		},
+		{
+			name:    "reject policy",
+			domain:  "contoso.com",
+			records: map[string]string{"_dmarc.contoso.com": "v=DMARC1; p=reject; rua=mailto:dmarc@contoso.com,mailto:d@rua.example.net"},
+			want: &DMARC{
+				Record:     "v=DMARC1; p=reject; rua=mailto:dmarc@contoso.com,mailto:d@rua.example.net",
+				Policy:     "reject",
+				Percentage: 100,
+				RUA:        []string{"dmarc@contoso.com", "d@rua.example.net"},
+			},
+		},
+		{
+			name:      "monitoring policy",
+			domain:    "contoso.com",
+			records:   map[string]string{"_dmarc.contoso.com": "v=DMARC1; p=none"},
+			want:      &DMARC{Record: "v=DMARC1; p=none", Policy: "none", Percentage: 100},
+			spoofable: true,
+		},
+		{
+			name:      "no percentage",
+			domain:    "contoso.com",
+			records:   map[string]string{"_dmarc.contoso.com": "v=DMARC1; p=quarantine; pct=0"},
+			want:      &DMARC{Record: "v=DMARC1; p=quarantine; pct=0", Policy: "quarantine"},
+			spoofable: true,
+		},
+		{
+			name:    "subdomain policy inherited from the organizational domain",
+			domain:  "mail.contoso.com",
+			records: map[string]string{"_dmarc.contoso.com": "v=DMARC1; p=reject; sp=none"},
+			want: &DMARC{
+				Record:          "v=DMARC1; p=reject; sp=none",
+				Policy:          "reject",
+				SubdomainPolicy: "none",
+				Percentage:      100,
+				Domain:          "contoso.com",
+			},
+			spoofable: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := resolvetest.NewResolver(t, resolvetest.Records{dns.TypeTXT: txtRecords(test.records)})
+			checker := NewChecker(resolver, nil)
+			got := checker.DMARC(test.domain)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("dmarc = %+v, want %+v", got, test.want)
+			}
+			if spoofable := !got.enforced(); spoofable != test.spoofable {
+				t.Errorf("spoofable = %t, want %t", spoofable, test.spoofable)
+			}
+		})
+	}
+}
+
+// txtRecords publishes a single txt record for each host
+func txtRecords(records map[string]string) map[string][]string {
+	txt := make(map[string][]string, len(records))
+	for host, record := range records {
+		txt[host] = []string{record}
+	}
+	return txt
+}
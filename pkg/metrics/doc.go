@@ -0,0 +1,3 @@
+// Package metrics exposes prometheus metrics about the
+// enumeration process and the requests sent by sources
+package metrics
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tenantfinder"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "HTTP requests sent per source and response status code.",
+	}, []string{"source", "status_code"})
+
+	rateLimitWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ratelimit_wait_seconds",
+		Help:      "Time requests spent waiting for the rate limiter per source.",
+		Buckets:   []float64{.001, .01, .1, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"source"})
+
+	throttleWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "throttle_wait_seconds",
+		Help:      "Time requests were held back per source after it was throttled.",
+		Buckets:   []float64{.001, .01, .1, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"source"})
+
+	throttling = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "throttling_total",
+		Help:      "Throttling responses received per source.",
+	}, []string{"source"})
+
+	results = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "results_total",
+		Help:      "Unique results returned per source.",
+	}, []string{"source"})
+
+	sourceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Errors returned per source and error class.",
+	}, []string{"source", "class"})
+
+	sourceDuration = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_duration_seconds_total",
+		Help:      "Time spent running each source.",
+	}, []string{"source"})
+
+	inputs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "inputs_total",
+		Help:      "Inputs enumerated.",
+	})
+
+	inputsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "inputs_in_flight",
+		Help:      "Inputs currently being enumerated.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		requests,
+		rateLimitWait,
+		throttleWait,
+		throttling,
+		results,
+		sourceErrors,
+		sourceDuration,
+		inputs,
+		inputsInFlight,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler returns the http handler serving the metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest counts a request sent by a source. A status code
+// of zero counts a request that did not receive a response.
+func ObserveRequest(sourceName string, statusCode int) {
+	status := "none"
+	if statusCode > 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	requests.WithLabelValues(sourceName, status).Inc()
+}
+
+// ObserveRateLimitWait records the time a request of a source waited for the rate limiter
+func ObserveRateLimitWait(sourceName string, wait time.Duration) {
+	rateLimitWait.WithLabelValues(sourceName).Observe(wait.Seconds())
+}
+
+// ObserveThrottleWait records the time a request of a throttled source was held back
+func ObserveThrottleWait(sourceName string, wait time.Duration) {
+	throttleWait.WithLabelValues(sourceName).Observe(wait.Seconds())
+}
+
+// ObserveThrottling counts a throttling response received by a source
+func ObserveThrottling(sourceName string) {
+	throttling.WithLabelValues(sourceName).Inc()
+}
+
+// ObserveError counts an error returned by a source under its class
+func ObserveError(sourceName string, class string) {
+	sourceErrors.WithLabelValues(sourceName, class).Inc()
+}
+
+// ObserveSource adds the results and run time of a source for an enumerated input
+func ObserveSource(sourceName string, sourceResults int, timeTaken time.Duration) {
+	results.WithLabelValues(sourceName).Add(float64(sourceResults))
+	sourceDuration.WithLabelValues(sourceName).Add(timeTaken.Seconds())
+}
+
+// InputStarted marks the start of the enumeration of an input
+func InputStarted() {
+	inputs.Inc()
+	inputsInFlight.Inc()
+}
+
+// InputFinished marks the end of the enumeration of an input
+func InputFinished() {
+	inputsInFlight.Dec()
+}
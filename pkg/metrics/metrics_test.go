@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveRequest("aad", http.StatusOK)
+	ObserveRequest("aad", 0)
+	ObserveRateLimitWait("aad", 250*time.Millisecond)
+	ObserveThrottleWait("autodiscover", 2*time.Second)
+	ObserveThrottling("autodiscover")
+	ObserveError("crtsh", "timeout")
+	ObserveSource("aad", 3, time.Second)
+	InputStarted()
+	InputStarted()
+	InputFinished()
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("could not get metrics: %s", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("could not read metrics: %s", err)
+	}
+
+	for _, want := range []string{
+		`tenantfinder_requests_total{source="aad",status_code="200"} 1`,
+		`tenantfinder_requests_total{source="aad",status_code="none"} 1`,
+		`tenantfinder_ratelimit_wait_seconds_bucket{source="aad",le="0.5"} 1`,
+		`tenantfinder_ratelimit_wait_seconds_sum{source="aad"} 0.25`,
+		`tenantfinder_throttle_wait_seconds_count{source="autodiscover"} 1`,
+		`tenantfinder_throttle_wait_seconds_sum{source="autodiscover"} 2`,
+		`tenantfinder_throttling_total{source="autodiscover"} 1`,
+		`tenantfinder_errors_total{class="timeout",source="crtsh"} 1`,
+		`tenantfinder_results_total{source="aad"} 3`,
+		`tenantfinder_source_duration_seconds_total{source="aad"} 1`,
+		`tenantfinder_inputs_total 2`,
+		`tenantfinder_inputs_in_flight 1`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
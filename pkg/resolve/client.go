@@ -2,6 +2,7 @@ package resolve
 
 import (
 	"github.com/projectdiscovery/dnsx/libs/dnsx"
+	"github.com/projectdiscovery/retryabledns"
 )
 
 // DefaultResolvers contains the default list of resolvers known to be good
@@ -18,10 +19,15 @@ var DefaultResolvers = []string{
 	"208.67.220.220:53", // OpenDNS Secondary
 }
 
+// maxRetries is the number of resolvers a failed query is retried against
+const maxRetries = 3
+
 // Resolver is a struct for resolving DNS names
 type Resolver struct {
 	DNSClient *dnsx.DNSX
 	Resolvers []string
+	// records queries the records of a specific type
+	records *retryabledns.Client
 }
 
 // New creates a new resolver struct with the default resolvers
@@ -30,3 +36,74 @@ func New() *Resolver {
 		Resolvers: []string{},
 	}
 }
+
+// NewClient creates a resolver ready to query the given resolvers,
+// or the default ones when none is given
+func NewClient(resolvers []string) (*Resolver, error) {
+	if len(resolvers) == 0 {
+		resolvers = DefaultResolvers
+	}
+
+	options := dnsx.DefaultOptions
+	options.BaseResolvers = resolvers
+	options.MaxRetries = maxRetries
+	dnsClient, err := dnsx.New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := retryabledns.NewWithOptions(retryabledns.Options{
+		BaseResolvers: resolvers,
+		MaxRetries:    maxRetries,
+		Hostsfile:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{DNSClient: dnsClient, Resolvers: resolvers, records: records}, nil
+}
+
+// A returns the ipv4 addresses of a host
+func (r *Resolver) A(host string) ([]string, error) {
+	data, err := r.records.A(host)
+	if err != nil {
+		return nil, err
+	}
+	return data.A, nil
+}
+
+// CNAME returns the canonical names of a host
+func (r *Resolver) CNAME(host string) ([]string, error) {
+	data, err := r.records.CNAME(host)
+	if err != nil {
+		return nil, err
+	}
+	return data.CNAME, nil
+}
+
+// MX returns the mail exchangers of a domain
+func (r *Resolver) MX(host string) ([]string, error) {
+	data, err := r.records.MX(host)
+	if err != nil {
+		return nil, err
+	}
+	return data.MX, nil
+}
+
+// TXT returns the text records of a host, the strings of each record joined
+func (r *Resolver) TXT(host string) ([]string, error) {
+	data, err := r.records.TXT(host)
+	if err != nil {
+		return nil, err
+	}
+	return data.TXT, nil
+}
+
+// SRV returns the targets of the service records of a host
+func (r *Resolver) SRV(host string) ([]string, error) {
+	data, err := r.records.SRV(host)
+	if err != nil {
+		return nil, err
+	}
+	return data.SRV, nil
+}
@@ -28,6 +28,8 @@ type HostEntry struct {
 	Domain string
 	Host   string
 	Source string
+	// Reference tells where the source found the host, such as the endpoint it asked
+	Reference string
 }
 
 // Result contains the result for a host resolution
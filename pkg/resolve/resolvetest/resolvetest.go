@@ -0,0 +1,66 @@
+// Package resolvetest provides a dns server answering from fixed records,
+// to test the code querying dns through a resolver
+package resolvetest
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+)
+
+// Records are the values the server answers with, by record type and host
+type Records map[uint16]map[string][]string
+
+// NewResolver starts a dns server answering with the records and returns a
+// resolver querying it, the server is stopped when the test ends
+func NewResolver(t testing.TB, records Records) *resolve.Resolver {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen for dns: %s", err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		response := new(dns.Msg)
+		response.SetReply(request)
+		for _, question := range request.Question {
+			name := strings.ToLower(question.Name)
+			values := records[question.Qtype][strings.TrimSuffix(name, ".")]
+			if len(values) == 0 {
+				response.Rcode = dns.RcodeNameError
+			}
+			for _, value := range values {
+				header := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: 60}
+				switch question.Qtype {
+				case dns.TypeTXT:
+					response.Answer = append(response.Answer, &dns.TXT{Hdr: header, Txt: []string{value}})
+				case dns.TypeCNAME:
+					response.Answer = append(response.Answer, &dns.CNAME{Hdr: header, Target: dns.Fqdn(value)})
+				case dns.TypeA:
+					response.Answer = append(response.Answer, &dns.A{Hdr: header, A: net.ParseIP(value)})
+				case dns.TypeMX:
+					response.Answer = append(response.Answer, &dns.MX{Hdr: header, Preference: 10, Mx: dns.Fqdn(value)})
+				case dns.TypeSRV:
+					response.Answer = append(response.Answer, &dns.SRV{Hdr: header, Priority: 0, Weight: 0, Port: 443, Target: dns.Fqdn(value)})
+				}
+			}
+		}
+		_ = w.WriteMsg(response)
+	})}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	resolver, err := resolve.NewClient([]string{conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("could not create resolver: %s", err)
+	}
+	return resolver
+}
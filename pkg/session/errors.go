@@ -0,0 +1,81 @@
+package session
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Classes of errors returned by requests
+const (
+	ErrorClassStatusCode = "status_code"
+	ErrorClassTimeout    = "timeout"
+	ErrorClassDNS        = "dns"
+	ErrorClassTLS        = "tls"
+	ErrorClassNetwork    = "network"
+	ErrorClassOther      = "other"
+)
+
+// StatusCodeError is returned when a request receives a response
+// with an unexpected status code
+type StatusCodeError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *StatusCodeError) Error() string {
+	return fmt.Sprintf("unexpected status code %d received from %s", e.StatusCode, e.URL)
+}
+
+// CertificateError is returned when the certificate of the remote end
+// cannot be verified, as opposed to other network failures
+type CertificateError struct {
+	URL string
+	Err error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("could not verify the certificate of %s: %s", e.URL, e.Err)
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// isCertificateError reports whether the error is caused by an invalid certificate
+func isCertificateError(err error) bool {
+	var certificateErr *CertificateError
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+	return errors.As(err, &certificateErr) || errors.As(err, &verificationErr) ||
+		errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certificateInvalidErr)
+}
+
+// ErrorClass returns the class of an error returned by a request
+func ErrorClass(err error) string {
+	var statusCodeErr *StatusCodeError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &statusCodeErr):
+		return ErrorClassStatusCode
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case isCertificateError(err):
+		return ErrorClassTLS
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	}
+	return ErrorClassOther
+}
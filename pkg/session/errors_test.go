@@ -0,0 +1,36 @@
+package session
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"status code", &StatusCodeError{StatusCode: 500, URL: "https://contoso.com"}, ErrorClassStatusCode},
+		{"deadline", fmt.Errorf("request failed: %w", context.DeadlineExceeded), ErrorClassTimeout},
+		{"dns", &url.Error{Op: "Get", URL: "https://contoso.com", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "contoso.com"}}}, ErrorClassDNS},
+		{"certificate", &CertificateError{URL: "contoso.com", Err: errors.New("expired")}, ErrorClassTLS},
+		{"unknown authority", &url.Error{Op: "Get", URL: "https://contoso.com", Err: x509.UnknownAuthorityError{}}, ErrorClassTLS},
+		{"hostname mismatch", &url.Error{Op: "Get", URL: "https://contoso.com", Err: x509.HostnameError{Host: "contoso.com", Certificate: &x509.Certificate{}}}, ErrorClassTLS},
+		{"invalid certificate", x509.CertificateInvalidError{Reason: x509.Expired}, ErrorClassTLS},
+		{"network", &url.Error{Op: "Get", URL: "https://contoso.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, ErrorClassNetwork},
+		{"other", errors.New("unexpected"), ErrorClassOther},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ErrorClass(test.err); got != test.want {
+				t.Errorf("ErrorClass() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
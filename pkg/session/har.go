@@ -0,0 +1,358 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// HAR is an http archive (http://www.softwareishard.com/blog/har-12-spec/)
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	// Source is the source that sent the request
+	Source string `json:"_source,omitempty"`
+	// Error is the error of a request that did not receive a response
+	Error string `json:"_error,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARRecorder records every request sent by the sessions using it
+type HARRecorder struct {
+	mutex   sync.Mutex
+	version string
+	entries []HAREntry
+}
+
+// NewHARRecorder creates a recorder naming the given tenantfinder version as creator
+func NewHARRecorder(version string) *HARRecorder {
+	return &HARRecorder{version: version}
+}
+
+// Write writes the recorded requests as an http archive
+func (r *HARRecorder) Write(w io.Writer) error {
+	r.mutex.Lock()
+	entries := append([]HAREntry(nil), r.entries...)
+	r.mutex.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "tenantfinder", Version: r.version},
+		Entries: entries,
+	}}
+	encoder := jsoniter.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(har)
+}
+
+func (r *HARRecorder) add(entry HAREntry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.entries = append(r.entries, entry)
+}
+
+// harTransport records the requests sent through the wrapped transport
+type harTransport struct {
+	next     http.RoundTripper
+	recorder *HARRecorder
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			requestBody, _ = io.ReadAll(body)
+			body.Close()
+		}
+	}
+
+	source, _ := req.Context().Value(CtxSourceArg).(string)
+	start := time.Now()
+	response, err := t.next.RoundTrip(req)
+	waited := time.Since(start)
+	if err != nil {
+		t.recorder.add(HAREntry{
+			StartedDateTime: start.UTC(),
+			Time:            milliseconds(waited),
+			Request:         harRequest(req, requestBody),
+			Response:        HARResponse{Cookies: []HARNameValue{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1},
+			Timings:         HARTimings{Wait: milliseconds(waited)},
+			Source:          source,
+			Error:           err.Error(),
+		})
+		return response, err
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+	if err != nil {
+		return response, err
+	}
+	received := time.Since(start) - waited
+
+	t.recorder.add(HAREntry{
+		StartedDateTime: start.UTC(),
+		Time:            milliseconds(waited + received),
+		Request:         harRequest(req, requestBody),
+		Response:        harResponse(response, responseBody),
+		Timings:         HARTimings{Wait: milliseconds(waited), Receive: milliseconds(received)},
+		Source:          source,
+	})
+	return response, nil
+}
+
+func harRequest(req *http.Request, body []byte) HARRequest {
+	request := HARRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			request.QueryString = append(request.QueryString, HARNameValue{Name: name, Value: value})
+		}
+	}
+	for _, cookie := range req.Cookies() {
+		request.Cookies = append(request.Cookies, HARNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	if len(body) > 0 {
+		request.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type"), Text: string(body)}
+	}
+	return request
+}
+
+func harResponse(response *http.Response, body []byte) HARResponse {
+	content := HARContent{Size: len(body), MimeType: response.Header.Get("Content-Type")}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+
+	harResponse := HARResponse{
+		Status:      response.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(response.Status, fmt.Sprint(response.StatusCode))),
+		HTTPVersion: response.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(response.Header),
+		Content:     content,
+		RedirectURL: response.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for _, cookie := range response.Cookies() {
+		harResponse.Cookies = append(harResponse.Cookies, HARNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	return harResponse
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	headers := []HARNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, HARNameValue{Name: name, Value: value})
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].Name < headers[j].Name
+	})
+	return headers
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// HARReplay serves the responses of an http archive instead of sending requests.
+// Requests are matched on their method, url and body, and repeated requests
+// are served the recorded responses in order.
+type HARReplay struct {
+	mutex   sync.Mutex
+	entries map[string][]HAREntry
+}
+
+// LoadHARReplay reads the responses to replay from an http archive
+func LoadHARReplay(path string) (*HARReplay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var har HAR
+	if err := jsoniter.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("could not parse har file %s: %w", path, err)
+	}
+
+	replay := &HARReplay{entries: make(map[string][]HAREntry)}
+	for _, entry := range har.Log.Entries {
+		var body string
+		if entry.Request.PostData != nil {
+			body = entry.Request.PostData.Text
+		}
+		key := replayKey(entry.Request.Method, entry.Request.URL, body)
+		replay.entries[key] = append(replay.entries[key], entry)
+	}
+	return replay, nil
+}
+
+func (r *HARReplay) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	key := replayKey(req.Method, req.URL.String(), string(body))
+	r.mutex.Lock()
+	entries := r.entries[key]
+	if len(entries) == 0 {
+		r.mutex.Unlock()
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+	}
+	entry := entries[0]
+	// The last response is kept to be served to further repetitions
+	if len(entries) > 1 {
+		r.entries[key] = entries[1:]
+	}
+	r.mutex.Unlock()
+
+	if entry.Error != "" {
+		return nil, fmt.Errorf("recorded error: %s", entry.Error)
+	}
+	recorded := entry.Response
+
+	content := []byte(recorded.Content.Text)
+	if recorded.Content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(recorded.Content.Text)
+		if err != nil {
+			return nil, err
+		}
+		content = decoded
+	}
+
+	header := make(http.Header)
+	for _, h := range recorded.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, recorded.StatusText),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Request:       req,
+	}, nil
+}
+
+func replayKey(method, url, body string) string {
+	return method + " " + url + "\n" + body
+}
+
+// SetHARRecorder records the requests of the session
+func (s *Session) SetHARRecorder(recorder *HARRecorder) {
+	s.Client.Transport = &harTransport{next: s.Client.Transport, recorder: recorder}
+}
+
+// SetHARReplay serves the requests of the session from the archive instead of
+// the network. Replayed requests are neither rate limited nor proxied.
+func (s *Session) SetHARReplay(replay *HARReplay) {
+	s.Client.Transport = replay
+	s.ProxyPool = nil
+	s.replay = true
+}
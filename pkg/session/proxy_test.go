@@ -0,0 +1,116 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// listen returns the address of a listener accepting connections until the test ends
+func listen(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// unreachable returns an address nothing listens on anymore
+func unreachable(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func proxyHosts(pool *ProxyPool) []string {
+	var hosts []string
+	for _, proxy := range pool.Proxies() {
+		hosts = append(hosts, proxy.Host)
+	}
+	return hosts
+}
+
+func TestProxyPoolHealthCheck(t *testing.T) {
+	alive, other, dead := listen(t), listen(t), unreachable(t)
+
+	tests := []struct {
+		name    string
+		proxies []string
+		want    []string
+		wantErr error
+	}{
+		{"all reachable", []string{alive, "socks5://" + other}, []string{alive, other}, nil},
+		{"unreachable removed", []string{alive, dead, other}, []string{alive, other}, nil},
+		{"none reachable", []string{dead}, nil, ErrNoProxy},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool, err := NewProxyPool(test.proxies, ProxyRotationRoundRobin)
+			if err != nil {
+				t.Fatalf("could not create pool: %s", err)
+			}
+			if err := pool.HealthCheck(context.Background(), time.Second); !errors.Is(err, test.wantErr) {
+				t.Errorf("HealthCheck() error = %v, want %v", err, test.wantErr)
+			}
+			if got := proxyHosts(pool); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("proxies = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProxyPoolFailed(t *testing.T) {
+	pool, err := NewProxyPool([]string{"127.0.0.1:8080", "127.0.0.1:8081"}, ProxyRotationRoundRobin)
+	if err != nil {
+		t.Fatalf("could not create pool: %s", err)
+	}
+	failing := pool.Proxies()[0]
+	failure := &net.OpError{Op: "proxyconnect", Err: errors.New("connection refused")}
+
+	// A successful request resets the consecutive failures
+	for i := 0; i < maxProxyFailures-1; i++ {
+		pool.Failed(failing, failure)
+	}
+	pool.Succeeded(failing)
+	pool.Failed(failing, failure)
+	if got := len(pool.Proxies()); got != 2 {
+		t.Fatalf("proxies after a success = %d, want 2", got)
+	}
+
+	for i := 0; i < maxProxyFailures-1; i++ {
+		pool.Failed(failing, failure)
+	}
+	if got, want := proxyHosts(pool), []string{"127.0.0.1:8081"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("proxies = %v, want %v", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		next, err := pool.Next()
+		if err != nil {
+			t.Fatalf("could not pick a proxy: %s", err)
+		}
+		if next.Host != "127.0.0.1:8081" {
+			t.Errorf("next proxy = %v, want 127.0.0.1:8081", next)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	// minRetryDelay is the delay before the first retry, doubled for every further one
+	minRetryDelay = time.Second
+	// maxRetryDelay caps the delay between two attempts of a request
+	maxRetryDelay = 30 * time.Second
+)
+
+// isIdempotent reports whether a request may be sent more than once. Sources
+// mark POST requests that only read data with CtxIdempotentArg.
+func isIdempotent(ctx context.Context, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	idempotent, _ := ctx.Value(CtxIdempotentArg).(bool)
+	return idempotent
+}
+
+// isRetryable reports whether a failed request may succeed when retried,
+// which is the case for network errors and server errors
+func isRetryable(err error) bool {
+	var statusCodeErr *StatusCodeError
+	if errors.As(err, &statusCodeErr) {
+		return statusCodeErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	switch ErrorClass(err) {
+	case ErrorClassTimeout, ErrorClassNetwork:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns the exponential backoff with jitter before the given retry
+func retryDelay(retry int) time.Duration {
+	delay := maxRetryDelay
+	if retry < 16 {
+		delay = min(minRetryDelay<<retry, maxRetryDelay)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
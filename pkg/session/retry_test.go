@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/ratelimit"
+)
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		// idempotent is the CtxIdempotentArg value of the context, when set
+		idempotent interface{}
+		want       bool
+	}{
+		{"get", http.MethodGet, nil, true},
+		{"head", http.MethodHead, nil, true},
+		{"post", http.MethodPost, nil, false},
+		{"marked post", http.MethodPost, true, true},
+		{"unmarked post", http.MethodPost, false, false},
+		{"put", http.MethodPut, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.idempotent != nil {
+				ctx = context.WithValue(ctx, CtxIdempotentArg, test.idempotent)
+			}
+			if got := isIdempotent(ctx, test.method); got != test.want {
+				t.Errorf("isIdempotent() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &StatusCodeError{StatusCode: http.StatusInternalServerError, URL: "https://contoso.com"}, true},
+		{"bad gateway", fmt.Errorf("request failed: %w", &StatusCodeError{StatusCode: http.StatusBadGateway, URL: "https://contoso.com"}), true},
+		{"not found", &StatusCodeError{StatusCode: http.StatusNotFound, URL: "https://contoso.com"}, false},
+		{"too many requests", &StatusCodeError{StatusCode: http.StatusTooManyRequests, URL: "https://contoso.com"}, false},
+		{"deadline", fmt.Errorf("request failed: %w", context.DeadlineExceeded), true},
+		{"connection refused", &url.Error{Op: "Get", URL: "https://contoso.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"no such host", &url.Error{Op: "Get", URL: "https://contoso.com", Err: &net.DNSError{Err: "no such host", Name: "contoso.com", IsNotFound: true}}, false},
+		{"dns timeout", &url.Error{Op: "Get", URL: "https://contoso.com", Err: &net.DNSError{Err: "i/o timeout", Name: "contoso.com", IsTimeout: true}}, true},
+		{"certificate", &CertificateError{URL: "contoso.com", Err: errors.New("expired")}, false},
+		{"other", errors.New("unexpected"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isRetryable(test.err); got != test.want {
+				t.Errorf("isRetryable() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		retry int
+		// base is the backoff the jitter picks a delay in the upper half of
+		base time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{4, 16 * time.Second},
+		{5, maxRetryDelay},
+		{16, maxRetryDelay},
+		{64, maxRetryDelay},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.retry), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := retryDelay(test.retry); got < test.base/2 || got >= test.base {
+					t.Fatalf("retryDelay() = %s, want within [%s, %s)", got, test.base/2, test.base)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPRequestRetries(t *testing.T) {
+	delay := minRetryDelay
+	minRetryDelay = time.Millisecond
+	t.Cleanup(func() { minRetryDelay = delay })
+
+	tests := []struct {
+		name       string
+		method     string
+		idempotent bool
+		// failures is the number of requests failing before the server answers
+		failures int
+		// statusCode fails the requests, or zero to close their connection
+		statusCode   int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"get recovered", http.MethodGet, false, 2, http.StatusServiceUnavailable, 3, false},
+		{"get exhausted", http.MethodGet, false, 3, http.StatusInternalServerError, 3, true},
+		{"client error", http.MethodGet, false, 1, http.StatusNotFound, 1, true},
+		{"network error", http.MethodGet, false, 1, 0, 2, false},
+		{"post", http.MethodPost, false, 1, http.StatusInternalServerError, 1, true},
+		{"idempotent post", http.MethodPost, true, 2, http.StatusInternalServerError, 3, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				mutex.Lock()
+				bodies = append(bodies, string(body))
+				attempt := len(bodies)
+				mutex.Unlock()
+
+				switch {
+				case attempt > test.failures:
+					w.WriteHeader(http.StatusOK)
+				case test.statusCode != 0:
+					w.WriteHeader(test.statusCode)
+				default:
+					conn, _, err := w.(http.Hijacker).Hijack()
+					if err == nil {
+						conn.Close()
+					}
+				}
+			}))
+			defer server.Close()
+
+			ctx := context.WithValue(context.Background(), CtxSourceArg, "test")
+			if test.idempotent {
+				ctx = context.WithValue(ctx, CtxIdempotentArg, true)
+			}
+			multiRateLimiter, err := ratelimit.NewMultiLimiter(ctx, &ratelimit.Options{Key: "test", IsUnlimited: true})
+			if err != nil {
+				t.Fatalf("could not create rate limiter: %s", err)
+			}
+			sess := NewSession("contoso.com", "", multiRateLimiter, 5)
+			sess.Retries = 2
+			defer sess.Close()
+
+			var body io.Reader
+			var wantBody string
+			if test.method == http.MethodPost {
+				wantBody = "payload"
+				body = strings.NewReader(wantBody)
+			}
+			response, err := sess.HTTPRequest(ctx, test.method, server.URL, "", nil, body, BasicAuth{})
+			sess.DiscardHTTPResponse(response)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("HTTPRequest() error = %v, want error %v", err, test.wantErr)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(bodies) != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", len(bodies), test.wantAttempts)
+			}
+			// Every attempt sends the whole body again
+			for i, got := range bodies {
+				if got != wantBody {
+					t.Errorf("body of attempt %d = %q, want %q", i+1, got, wantBody)
+				}
+			}
+		})
+	}
+}
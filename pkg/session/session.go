@@ -13,6 +13,9 @@ import (
 
 	"github.com/projectdiscovery/ratelimit"
 
+	"github.com/upmux/tenantfinder/pkg/metrics"
+	"github.com/upmux/tenantfinder/pkg/resolve"
+
 	"github.com/corpix/uarand"
 
 	"github.com/projectdiscovery/gologger"
@@ -24,6 +27,19 @@ type Session struct {
 	// Client is the current http client
 	Client           *http.Client
 	MultiRateLimiter *ratelimit.MultiLimiter
+	// Throttle slows down the sources throttled by the remote end when set
+	Throttle *Throttle
+	// Retries is the number of times a failed idempotent request is retried
+	Retries int
+	// ProxyPool rotates the requests over a list of proxies when set
+	ProxyPool *ProxyPool
+	// Resolver queries the dns records sources need
+	Resolver *resolve.Resolver
+	// NoVerifyHosts makes sources return the hostnames they derive without checking they resolve
+	NoVerifyHosts bool
+	// replay is set when the responses are served from an http archive
+	replay bool
+	shared shared
 }
 
 // BasicAuth request's Authorization header
@@ -36,6 +52,8 @@ type CtxArg string
 
 const (
 	CtxSourceArg CtxArg = "source"
+	// CtxIdempotentArg marks the requests of a context as safe to retry
+	CtxIdempotentArg CtxArg = "idempotent"
 )
 
 // NewSession creates a new session object for a url
@@ -44,23 +62,29 @@ func NewSession(query string, proxy string, multiRateLimiter *ratelimit.MultiLim
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 100,
 		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
+			MinVersion: tls.VersionTLS12,
 		},
 		Dial: (&net.Dialer{
 			Timeout: time.Duration(timeout) * time.Second,
 		}).Dial,
 	}
 
-	// Add proxy
+	// Add proxy, the proxy picked from the pool for a request takes precedence
+	var staticProxy *url.URL
 	if proxy != "" {
-		proxyURL, _ := url.Parse(proxy)
-		if proxyURL == nil {
-			// Log warning but continue anyway
-			gologger.Warning().Msgf("Invalid proxy provided: %s", proxy)
+		proxyURL, err := ParseProxy(proxy)
+		if err != nil {
+			gologger.Error().Msgf("Not using proxy: %s", err)
 		} else {
-			Transport.Proxy = http.ProxyURL(proxyURL)
+			staticProxy = proxyURL
 		}
 	}
+	Transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if proxyURL := requestProxy(req.Context()); proxyURL != nil {
+			return proxyURL, nil
+		}
+		return staticProxy, nil
+	}
 
 	client := &http.Client{
 		Transport: Transport,
@@ -95,8 +119,46 @@ func (s *Session) SimplePost(ctx context.Context, postURL, contentType string, b
 	return s.HTTPRequest(ctx, http.MethodPost, postURL, "", map[string]string{"Content-Type": contentType}, body, BasicAuth{})
 }
 
-// HTTPRequest makes any HTTP request to a URL with extended parameters
+// HTTPRequest makes any HTTP request to a URL with extended parameters. Idempotent
+// requests failing with a network or server error are retried with a backoff.
 func (s *Session) HTTPRequest(ctx context.Context, method, requestURL, cookies string, headers map[string]string, body io.Reader, basicAuth BasicAuth) (*http.Response, error) {
+	retries := 0
+	if isIdempotent(ctx, method) {
+		retries = s.Retries
+	}
+
+	// The body is buffered so it can be sent again
+	var payload []byte
+	if body != nil && retries > 0 {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+
+	for retry := 0; ; retry++ {
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+
+		response, err := s.sendRequest(ctx, method, requestURL, cookies, headers, body, basicAuth)
+		if err == nil || retry >= retries || ctx.Err() != nil || !isRetryable(err) {
+			return response, err
+		}
+		s.DiscardHTTPResponse(response)
+
+		delay := retryDelay(retry)
+		gologger.Debug().Msgf("Retrying request against %s in %s: %s\n", requestURL, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// sendRequest sends a single request once the rate limits allow it
+func (s *Session) sendRequest(ctx context.Context, method, requestURL, cookies string, headers map[string]string, body io.Reader, basicAuth BasicAuth) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
 	if err != nil {
 		return nil, err
@@ -120,12 +182,64 @@ func (s *Session) HTTPRequest(ctx context.Context, method, requestURL, cookies s
 	}
 
 	sourceName := ctx.Value(CtxSourceArg).(string)
-	mrlErr := s.MultiRateLimiter.Take(sourceName)
-	if mrlErr != nil {
-		return nil, mrlErr
+
+	var proxyURL *url.URL
+	if s.ProxyPool != nil {
+		if proxyURL, err = s.ProxyPool.Next(); err != nil {
+			return nil, err
+		}
+		req = req.WithContext(context.WithValue(req.Context(), proxyCtxKey{}, proxyURL))
 	}
 
-	return httpRequestWrapper(s.Client, req)
+	// Replayed requests do not reach the network and so are not rate limited
+	if !s.replay {
+		waitStart := time.Now()
+		mrlErr := s.MultiRateLimiter.Take(s.ProxyPool.RateLimitKey(sourceName, proxyURL))
+		if mrlErr != nil {
+			return nil, mrlErr
+		}
+		metrics.ObserveRateLimitWait(sourceName, time.Since(waitStart))
+
+		if s.Throttle != nil {
+			throttleStart := time.Now()
+			if err := s.Throttle.Wait(ctx, sourceName); err != nil {
+				return nil, err
+			}
+			metrics.ObserveThrottleWait(sourceName, time.Since(throttleStart))
+		}
+	}
+
+	response, err := httpRequestWrapper(s.Client, req)
+	if response != nil {
+		metrics.ObserveRequest(sourceName, response.StatusCode)
+	} else {
+		metrics.ObserveRequest(sourceName, 0)
+	}
+
+	if proxyURL != nil {
+		if isProxyError(err) {
+			s.ProxyPool.Failed(proxyURL, err)
+		} else if response != nil {
+			s.ProxyPool.Succeeded(proxyURL)
+		}
+	}
+
+	if s.Throttle != nil && response != nil {
+		if isThrottlingResponse(response) {
+			s.Throttle.Throttled(sourceName, retryAfter(response))
+		} else if err == nil {
+			s.Throttle.Succeeded(sourceName)
+		}
+	}
+	return response, err
+}
+
+// Throttled reports throttling the source detected in a response body,
+// such as a SOAP fault, so its following requests are slowed down
+func (s *Session) Throttled(ctx context.Context, retryAfter time.Duration) {
+	if s.Throttle != nil {
+		s.Throttle.Throttled(ctx.Value(CtxSourceArg).(string), retryAfter)
+	}
 }
 
 // DiscardHTTPResponse discards the response content by demand
@@ -149,18 +263,25 @@ func (s *Session) Close() {
 func httpRequestWrapper(client *http.Client, request *http.Request) (*http.Response, error) {
 	response, err := client.Do(request)
 	if err != nil {
+		if isCertificateError(err) {
+			return nil, &CertificateError{URL: request.URL.Host, Err: err}
+		}
 		return nil, err
 	}
 
 	if response.StatusCode != http.StatusOK {
 		requestURL, _ := url.QueryUnescape(request.URL.String())
 
+		// The body is buffered so sources can still inspect it, e.g. for faults
+		buffer := new(bytes.Buffer)
+		_, _ = buffer.ReadFrom(response.Body)
+		response.Body.Close()
+		response.Body = io.NopCloser(bytes.NewReader(buffer.Bytes()))
+
 		gologger.Debug().MsgFunc(func() string {
-			buffer := new(bytes.Buffer)
-			_, _ = buffer.ReadFrom(response.Body)
 			return fmt.Sprintf("Response for failed request against %s:\n%s", requestURL, buffer.String())
 		})
-		return response, fmt.Errorf("unexpected status code %d received from %s", response.StatusCode, requestURL)
+		return response, &StatusCodeError{StatusCode: response.StatusCode, URL: requestURL}
 	}
 	return response, nil
 }
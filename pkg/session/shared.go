@@ -0,0 +1,38 @@
+package session
+
+import "sync"
+
+// shared holds the lookups several sources of an enumeration depend on,
+// such as the federation information of the input, so they run only once
+type shared struct {
+	mutex sync.Mutex
+	calls map[string]*sharedCall
+}
+
+type sharedCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Shared runs lookup once for the key during the enumeration of the session
+// and returns its result to every caller, waiting for it if it is running
+func (s *Session) Shared(key string, lookup func() (interface{}, error)) (interface{}, error) {
+	s.shared.mutex.Lock()
+	if s.shared.calls == nil {
+		s.shared.calls = make(map[string]*sharedCall)
+	}
+	call, ok := s.shared.calls[key]
+	if ok {
+		s.shared.mutex.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call = &sharedCall{done: make(chan struct{})}
+	s.shared.calls[key] = call
+	s.shared.mutex.Unlock()
+
+	call.value, call.err = lookup()
+	close(call.done)
+	return call.value, call.err
+}
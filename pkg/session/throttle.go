@@ -0,0 +1,163 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+
+	"github.com/upmux/tenantfinder/pkg/metrics"
+)
+
+const (
+	// minThrottleDelay is the delay between requests after a source was first throttled
+	minThrottleDelay = time.Second
+	// maxThrottleDelay caps the delay between requests of a throttled source
+	maxThrottleDelay = 2 * time.Minute
+	// throttleRecovery is the number of successful requests after which the delay is halved
+	throttleRecovery = 5
+)
+
+// Throttle slows down the requests of sources the remote end is throttling,
+// on top of their configured rate limits. Every throttling response doubles
+// the delay between requests of the source and every few successful requests
+// halve it again until the source is back at its configured rate.
+type Throttle struct {
+	mutex   sync.Mutex
+	sources map[string]*throttleState
+}
+
+type throttleState struct {
+	delay     time.Duration
+	next      time.Time
+	successes int
+	events    int
+}
+
+// NewThrottle creates a throttle without any slowed down source
+func NewThrottle() *Throttle {
+	return &Throttle{sources: make(map[string]*throttleState)}
+}
+
+func (t *Throttle) state(source string) *throttleState {
+	state, ok := t.sources[source]
+	if !ok {
+		state = &throttleState{}
+		t.sources[source] = state
+	}
+	return state
+}
+
+// Wait blocks until the next request of the source may be sent
+func (t *Throttle) Wait(ctx context.Context, source string) error {
+	t.mutex.Lock()
+	state := t.state(source)
+	now := time.Now()
+	wait := state.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	state.next = now.Add(wait + state.delay)
+	t.mutex.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// Throttled slows down the source after the remote end throttled it. No
+// request is sent before retryAfter has passed when it is given.
+func (t *Throttle) Throttled(source string, retryAfter time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state := t.state(source)
+	state.events++
+	state.successes = 0
+	state.delay *= 2
+	if state.delay < minThrottleDelay {
+		state.delay = minThrottleDelay
+	}
+	if state.delay > maxThrottleDelay {
+		state.delay = maxThrottleDelay
+	}
+
+	pause := state.delay
+	if retryAfter > pause {
+		pause = retryAfter
+	}
+	if next := time.Now().Add(pause); next.After(state.next) {
+		state.next = next
+	}
+
+	metrics.ObserveThrottling(source)
+	gologger.Debug().Msgf("Source %s is being throttled, slowing down to one request every %s", source, state.delay)
+}
+
+// Succeeded gradually speeds a throttled source back up
+func (t *Throttle) Succeeded(source string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state := t.state(source)
+	if state.delay == 0 {
+		return
+	}
+	state.successes++
+	if state.successes >= throttleRecovery {
+		state.successes = 0
+		state.delay /= 2
+		if state.delay < minThrottleDelay {
+			state.delay = 0
+		}
+	}
+}
+
+// TakeEvents returns the number of throttling events of the source
+// since the last call and resets it
+func (t *Throttle) TakeEvents(source string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state := t.state(source)
+	events := state.events
+	state.events = 0
+	return events
+}
+
+// isThrottlingResponse reports whether the response signals throttling. The
+// Retry-After header only tells how long to back off on these statuses, as
+// redirects and successful responses carry it as well.
+func isThrottlingResponse(response *http.Response) bool {
+	return IsThrottlingStatus(response.StatusCode)
+}
+
+// IsThrottlingStatus reports whether the status code signals throttling, in
+// which case the session already slowed the source down
+func IsThrottlingStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// retryAfter parses the Retry-After header given in seconds or as an http date
+func retryAfter(response *http.Response) time.Duration {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+package session
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsThrottlingResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		retryAfter string
+		want       bool
+	}{
+		{"too many requests", http.StatusTooManyRequests, "", true},
+		{"service unavailable with retry-after", http.StatusServiceUnavailable, "30", true},
+		{"redirect with retry-after", http.StatusFound, "30", false},
+		{"success with retry-after", http.StatusOK, "30", false},
+		{"server error", http.StatusInternalServerError, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := &http.Response{StatusCode: test.statusCode, Header: make(http.Header)}
+			if test.retryAfter != "" {
+				response.Header.Set("Retry-After", test.retryAfter)
+			}
+			if got := isThrottlingResponse(response); got != test.want {
+				t.Errorf("isThrottlingResponse() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSOptions configures how the certificates of the remote ends are verified
+type TLSOptions struct {
+	// Insecure disables the verification of server certificates
+	Insecure bool
+	// CACert is a pem file of certificate authorities trusted in addition to the system ones
+	CACert string
+	// ClientCert and ClientKey are the pem files of the certificate
+	// presented to servers and proxies requesting one
+	ClientCert string
+	ClientKey  string
+}
+
+// NewTLSConfig creates the tls configuration of the sessions
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: options.Insecure,
+	}
+
+	if options.CACert != "" {
+		data, err := os.ReadFile(options.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", options.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	if options.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(options.ClientCert, options.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// SetTLSConfig replaces the tls configuration of the session
+func (s *Session) SetTLSConfig(config *tls.Config) {
+	if transport, ok := s.Client.Transport.(*http.Transport); ok {
+		transport.TLSClientConfig = config
+	}
+}
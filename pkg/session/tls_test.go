@@ -0,0 +1,77 @@
+package session
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTLSConfigVerification(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	// The rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCert, data, 0600); err != nil {
+		t.Fatalf("could not write ca certificate: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		options TLSOptions
+		// host replaces the address of the server in the request url
+		host            string
+		wantCertificate bool
+	}{
+		{"untrusted authority", TLSOptions{}, "", true},
+		{"trusted authority", TLSOptions{CACert: caCert}, "", false},
+		{"hostname mismatch", TLSOptions{CACert: caCert}, "localhost", true},
+		{"insecure", TLSOptions{Insecure: true}, "localhost", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := NewTLSConfig(test.options)
+			if err != nil {
+				t.Fatalf("could not create tls config: %s", err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			defer client.CloseIdleConnections()
+
+			requestURL := server.URL
+			if test.host != "" {
+				requestURL = strings.Replace(requestURL, "127.0.0.1", test.host, 1)
+			}
+			request, err := http.NewRequest(http.MethodGet, requestURL, nil)
+			if err != nil {
+				t.Fatalf("could not create request: %s", err)
+			}
+
+			response, err := httpRequestWrapper(client, request)
+			if response != nil {
+				response.Body.Close()
+			}
+			var certificateErr *CertificateError
+			if got := errors.As(err, &certificateErr); got != test.wantCertificate {
+				t.Fatalf("certificate error = %v, want %v", err, test.wantCertificate)
+			}
+			if test.wantCertificate && ErrorClass(err) != ErrorClassTLS {
+				t.Errorf("ErrorClass() = %s, want %s", ErrorClass(err), ErrorClassTLS)
+			}
+			if !test.wantCertificate && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
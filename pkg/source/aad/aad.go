@@ -3,6 +3,7 @@ package aad
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -80,6 +81,7 @@ type ServerVersionInfo struct {
 
 type ResponseBody struct {
 	GetFederationInfoResponse GetFederationInfoResponse `xml:"GetFederationInformationResponseMessage"`
+	Fault                     *Fault                    `xml:"Fault"`
 }
 
 type GetFederationInfoResponse struct {
@@ -107,48 +109,71 @@ type TokenIssuer struct {
 	Uri      string `xml:"Uri"`
 }
 
+// Endpoint is the Exchange Online autodiscover service answering for every tenant
+const Endpoint = "https://autodiscover-s.outlook.com/autodiscover/autodiscover.svc"
+
+// SourceName is the name of the source, under which the shared lookup of the
+// federation information is rate limited and throttled whoever runs it
+const SourceName = "aad"
+
 type Source struct {
-	timeTaken time.Duration
-	errors    int
-	results   int
+	source.Counters
 }
 
 func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
 	results := make(chan source.Result)
-	s.errors = 0
-	s.results = 0
+	s.Reset()
 
 	go func() {
 		defer func(startTime time.Time) {
-			s.timeTaken = time.Since(startTime)
+			s.Finish(startTime)
 			close(results)
 		}(time.Now())
 
-		domains, err := s.fetchDomains(ctx, sess, domain)
+		response, err := FederationInformation(ctx, sess, domain)
 		if err != nil {
-			results <- source.Result{
-				Source: s.Name(),
-				Type:   source.Error,
-				Error:  fmt.Errorf("failed to fetch domains: %v", err),
-			}
-			s.errors++
+			s.SendError(results, s.Name(), fmt.Errorf("failed to fetch domains: %w", err))
 			return
 		}
 
-		for _, domain := range domains {
+		for _, domain := range response.Domains.Domain {
 			results <- source.Result{
 				Source: s.Name(),
 				Type:   source.Domain,
 				Value:  domain,
 			}
-			s.results++
+			s.Results++
+		}
+
+		for _, issuer := range response.TokenIssuers.TokenIssuer {
+			results <- source.Result{
+				Source:    s.Name(),
+				Type:      source.Issuer,
+				Value:     issuer.Uri,
+				Reference: issuer.Endpoint,
+			}
 		}
 	}()
 
 	return results
 }
 
-func (s *Source) fetchDomains(ctx context.Context, sess *session.Session, rootUrl string) ([]string, error) {
+// FederationInformation returns the federation information of the domain from
+// Exchange Online. It is fetched once per enumeration, whichever source asks first,
+// and the sources asking for it list aad among their dependencies.
+func FederationInformation(ctx context.Context, sess *session.Session, domain string) (*Response, error) {
+	response, err := sess.Shared("aad:"+domain, func() (interface{}, error) {
+		return FetchFederationInformation(context.WithValue(ctx, session.CtxSourceArg, SourceName), sess, Endpoint, domain)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.(*Response), nil
+}
+
+// FetchFederationInformation sends the GetFederationInformation request for the
+// domain to the autodiscover service at endpoint
+func FetchFederationInformation(ctx context.Context, sess *session.Session, endpoint, domain string) (*Response, error) {
 	envelope := &Envelope{
 		SoapNS: "http://schemas.xmlsoap.org/soap/envelope/",
 		ExmNS:  "http://schemas.microsoft.com/exchange/services/2006/messages",
@@ -163,7 +188,7 @@ func (s *Source) fetchDomains(ctx context.Context, sess *session.Session, rootUr
 			},
 			To: To{
 				MustUnderstand: "1",
-				Value:          "https://autodiscover-s.outlook.com/autodiscover/autodiscover.svc",
+				Value:          endpoint,
 			},
 			ReplyTo: ReplyTo{
 				Address: "http://www.w3.org/2005/08/addressing/anonymous",
@@ -173,7 +198,7 @@ func (s *Source) fetchDomains(ctx context.Context, sess *session.Session, rootUr
 			GetFederationInfo: GetFederationInfo{
 				XMLNs: "http://schemas.microsoft.com/exchange/2010/Autodiscover",
 				Request: Request{
-					Domain: rootUrl, // Use the target domain from the Run method
+					Domain: domain,
 				},
 			},
 		},
@@ -187,40 +212,67 @@ func (s *Source) fetchDomains(ctx context.Context, sess *session.Session, rootUr
 	// Add XML declaration
 	xmlString := `<?xml version="1.0" encoding="utf-8"?>` + "\n" + string(xmlData)
 
-	// Update endpoint URL
-	url := "https://autodiscover-s.outlook.com/autodiscover/autodiscover.svc"
-
 	headers := map[string]string{
 		"User-Agent":   "AutodiscoverClient",
 		"Content-Type": "text/xml; charset=utf-8",
 		"SOAPAction":   "http://schemas.microsoft.com/exchange/2010/Autodiscover/Autodiscover/GetFederationInformation",
 	}
 
-	resp, err := sess.Post(ctx, url, "", headers, strings.NewReader(xmlString))
+	// GetFederationInformation only reads, so it is safe to retry
+	ctx = context.WithValue(ctx, session.CtxIdempotentArg, true)
+	resp, err := sess.Post(ctx, endpoint, "", headers, strings.NewReader(xmlString))
 	if err != nil {
-		return nil, fmt.Errorf("failed to send SOAP request: %v", err)
+		if resp != nil {
+			body, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if readErr == nil {
+				if faultErr := failedRequestError(statusCode(err), body); faultErr != nil {
+					// The session already slowed the source down after a throttling status
+					if !session.IsThrottlingStatus(statusCode(err)) {
+						reportThrottling(ctx, sess, faultErr)
+					}
+					return nil, faultErr
+				}
+			}
+		}
+		return nil, fmt.Errorf("failed to send SOAP request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	var response ResponseEnvelope
 	if err := xml.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+		return nil, &Error{Kind: ErrMalformedResponse, Message: err.Error()}
+	}
+	if response.Body.Fault != nil {
+		faultErr := faultError(response.Body.Fault, body)
+		reportThrottling(ctx, sess, faultErr)
+		return nil, faultErr
 	}
 
-	if response.Body.GetFederationInfoResponse.Response.ErrorCode != "NoError" {
-		return nil, fmt.Errorf("federation info request failed: %s", response.Body.GetFederationInfoResponse.Response.ErrorMessage)
+	if err := responseError(&response.Body.GetFederationInfoResponse.Response); err != nil {
+		reportThrottling(ctx, sess, err)
+		return nil, err
 	}
 
-	return response.Body.GetFederationInfoResponse.Response.Domains.Domain, nil
+	return &response.Body.GetFederationInfoResponse.Response, nil
+}
+
+// reportThrottling slows the source down when the service asked to back off,
+// as it does so with a SOAP fault rather than a 429
+func reportThrottling(ctx context.Context, sess *session.Session, err error) {
+	var aadErr *Error
+	if errors.As(err, &aadErr) && aadErr.Kind == ErrServerBusy {
+		sess.Throttled(ctx, aadErr.BackOff)
+	}
 }
 
 func (s *Source) Name() string {
-	return "aad"
+	return SourceName
 }
 
 func (s *Source) IsDefault() bool {
@@ -234,11 +286,3 @@ func (s *Source) NeedsKey() bool {
 func (s *Source) AddApiKeys(_ []string) {
 	// No API keys needed
 }
-
-func (s *Source) Statistics() source.Statistics {
-	return source.Statistics{
-		Errors:    s.errors,
-		Results:   s.results,
-		TimeTaken: s.timeTaken,
-	}
-}
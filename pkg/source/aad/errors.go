@@ -0,0 +1,175 @@
+package aad
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// Kinds of errors returned by the autodiscover service
+var (
+	// ErrInvalidDomain is returned for a domain no tenant has registered
+	ErrInvalidDomain = errors.New("invalid domain")
+	// ErrNotFederated is returned for a domain without federation information
+	ErrNotFederated = errors.New("domain not federated")
+	// ErrThrottled is returned when the request was rejected with 429 or 503
+	ErrThrottled = errors.New("throttled")
+	// ErrServerBusy is returned when the service asked to back off
+	ErrServerBusy = errors.New("server busy")
+	// ErrMalformedResponse is returned when the response cannot be parsed
+	ErrMalformedResponse = errors.New("malformed response")
+	// ErrFault is returned for any other SOAP fault or error code
+	ErrFault = errors.New("request failed")
+)
+
+// Error is an error returned by the autodiscover service
+type Error struct {
+	// Kind is one of the Err* kinds of errors
+	Kind    error
+	Code    string
+	Message string
+	// BackOff is the time the service asked to wait before the next request
+	BackOff time.Duration
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Code != "" && e.Message != "":
+		return fmt.Sprintf("%s: %s (%s)", e.Kind, e.Message, e.Code)
+	case e.Code != "":
+		return fmt.Sprintf("%s (%s)", e.Kind, e.Code)
+	case e.Message != "":
+		return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+	}
+	return e.Kind.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Is matches the errors shared by all sources
+func (e *Error) Is(target error) bool {
+	switch target {
+	case source.ErrNoTenant:
+		return e.Kind == ErrInvalidDomain || e.Kind == ErrNotFederated
+	case source.ErrThrottled:
+		return e.Kind == ErrThrottled || e.Kind == ErrServerBusy
+	}
+	return false
+}
+
+// Fault is a SOAP 1.1 or 1.2 fault
+type Fault struct {
+	FaultCode   string      `xml:"faultcode"`
+	FaultString string      `xml:"faultstring"`
+	Code        string      `xml:"Code>Value"`
+	Reason      string      `xml:"Reason>Text"`
+	Detail      FaultDetail `xml:"detail"`
+}
+
+// FaultDetail is the detail of an Exchange fault
+type FaultDetail struct {
+	ErrorCode    string `xml:"ErrorCode"`
+	ResponseCode string `xml:"ResponseCode"`
+	Message      string `xml:"Message"`
+}
+
+// responseError returns the error of a response, nil for NoError
+func responseError(response *Response) error {
+	switch response.ErrorCode {
+	case "NoError":
+		return nil
+	case "":
+		return &Error{Kind: ErrMalformedResponse, Message: "missing error code"}
+	case "InvalidDomain":
+		return &Error{Kind: ErrInvalidDomain, Code: response.ErrorCode, Message: response.ErrorMessage}
+	case "NotFederated":
+		return &Error{Kind: ErrNotFederated, Code: response.ErrorCode, Message: response.ErrorMessage}
+	case "ServerBusy":
+		return &Error{Kind: ErrServerBusy, Code: response.ErrorCode, Message: response.ErrorMessage}
+	}
+	return &Error{Kind: ErrFault, Code: response.ErrorCode, Message: response.ErrorMessage}
+}
+
+// failedRequestError returns the error of a request failed with an
+// unexpected status code, parsing the SOAP fault of the body if any
+func failedRequestError(statusCode int, body []byte) error {
+	var envelope ResponseEnvelope
+	if err := xml.Unmarshal(body, &envelope); err == nil && envelope.Body.Fault != nil {
+		return faultError(envelope.Body.Fault, body)
+	}
+
+	if statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable {
+		return &Error{Kind: ErrThrottled, Code: strconv.Itoa(statusCode)}
+	}
+	return nil
+}
+
+// faultError returns the error of a SOAP fault
+func faultError(fault *Fault, body []byte) error {
+	code := fault.Detail.ErrorCode
+	if code == "" {
+		code = fault.Detail.ResponseCode
+	}
+	if code == "" {
+		code = fault.FaultCode
+	}
+	if code == "" {
+		code = fault.Code
+	}
+	// Fault codes are qualified names such as a:ErrorServerBusy
+	if _, local, ok := strings.Cut(code, ":"); ok {
+		code = local
+	}
+	message := fault.Detail.Message
+	if message == "" {
+		message = fault.FaultString
+	}
+	if message == "" {
+		message = fault.Reason
+	}
+
+	switch code {
+	case "ErrorServerBusy", "ServerBusy":
+		return &Error{Kind: ErrServerBusy, Code: code, Message: message, BackOff: backOff(body)}
+	case "InvalidDomain":
+		return &Error{Kind: ErrInvalidDomain, Code: code, Message: message}
+	case "NotFederated":
+		return &Error{Kind: ErrNotFederated, Code: code, Message: message}
+	}
+	return &Error{Kind: ErrFault, Code: code, Message: message}
+}
+
+// backOffPattern matches the back off hint of an ErrorServerBusy fault
+var backOffPattern = regexp.MustCompile(`Name="BackOffMilliseconds">\s*(\d+)\s*<`)
+
+// backOff returns the back off requested by an ErrorServerBusy fault
+func backOff(body []byte) time.Duration {
+	match := backOffPattern.FindSubmatch(body)
+	if match == nil {
+		return 0
+	}
+	milliseconds, err := strconv.Atoi(string(match[1]))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(milliseconds) * time.Millisecond
+}
+
+// statusCode returns the status code of a request failed with an unexpected one
+func statusCode(err error) int {
+	var statusCodeErr *session.StatusCodeError
+	if errors.As(err, &statusCodeErr) {
+		return statusCodeErr.StatusCode
+	}
+	return 0
+}
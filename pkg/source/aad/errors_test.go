@@ -0,0 +1,140 @@
+package aad
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		name     string
+		response Response
+		want     error
+	}{
+		{name: "no error", response: Response{ErrorCode: "NoError"}},
+		{name: "missing error code", response: Response{}, want: &Error{Kind: ErrMalformedResponse, Message: "missing error code"}},
+		{
+			name:     "invalid domain",
+			response: Response{ErrorCode: "InvalidDomain", ErrorMessage: "The domain is invalid."},
+			want:     &Error{Kind: ErrInvalidDomain, Code: "InvalidDomain", Message: "The domain is invalid."},
+		},
+		{name: "not federated", response: Response{ErrorCode: "NotFederated"}, want: &Error{Kind: ErrNotFederated, Code: "NotFederated"}},
+		{name: "server busy", response: Response{ErrorCode: "ServerBusy"}, want: &Error{Kind: ErrServerBusy, Code: "ServerBusy"}},
+		{name: "other error code", response: Response{ErrorCode: "InternalServerError"}, want: &Error{Kind: ErrFault, Code: "InternalServerError"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := responseError(&test.response); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("error = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFailedRequestError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       error
+	}{
+		{
+			name:       "soap 1.1 server busy fault with back off",
+			statusCode: http.StatusInternalServerError,
+			body: `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+<faultcode xmlns:a="http://schemas.microsoft.com/exchange/services/2006/types">a:ErrorServerBusy</faultcode>
+<faultstring>The server cannot service this request right now.</faultstring>
+<detail><e:Value Name="BackOffMilliseconds">2500</e:Value></detail>
+</s:Fault></s:Body></s:Envelope>`,
+			want: &Error{Kind: ErrServerBusy, Code: "ErrorServerBusy", Message: "The server cannot service this request right now.", BackOff: 2500 * time.Millisecond},
+		},
+		{
+			name:       "soap 1.2 fault",
+			statusCode: http.StatusInternalServerError,
+			body: `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault>
+<s:Code><s:Value>s:Receiver</s:Value></s:Code><s:Reason><s:Text>Internal error</s:Text></s:Reason>
+</s:Fault></s:Body></s:Envelope>`,
+			want: &Error{Kind: ErrFault, Code: "Receiver", Message: "Internal error"},
+		},
+		{
+			name:       "exchange fault detail",
+			statusCode: http.StatusInternalServerError,
+			body: `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+<faultcode>s:Client</faultcode><faultstring>Fault</faultstring>
+<detail><ErrorCode>InvalidDomain</ErrorCode><Message>The domain is invalid.</Message></detail>
+</s:Fault></s:Body></s:Envelope>`,
+			want: &Error{Kind: ErrInvalidDomain, Code: "InvalidDomain", Message: "The domain is invalid."},
+		},
+		{name: "throttled without fault", statusCode: http.StatusTooManyRequests, body: "Too Many Requests", want: &Error{Kind: ErrThrottled, Code: "429"}},
+		{name: "unavailable without fault", statusCode: http.StatusServiceUnavailable, want: &Error{Kind: ErrThrottled, Code: "503"}},
+		{name: "other status code", statusCode: http.StatusBadGateway, body: "Bad Gateway"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := failedRequestError(test.statusCode, []byte(test.body)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("error = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       *Error
+		message   string
+		noTenant  bool
+		throttled bool
+	}{
+		{
+			name:     "invalid domain",
+			err:      &Error{Kind: ErrInvalidDomain, Code: "InvalidDomain", Message: "The domain is invalid."},
+			message:  "invalid domain: The domain is invalid. (InvalidDomain)",
+			noTenant: true,
+		},
+		{name: "not federated", err: &Error{Kind: ErrNotFederated, Code: "NotFederated"}, message: "domain not federated (NotFederated)", noTenant: true},
+		{name: "throttled", err: &Error{Kind: ErrThrottled, Code: "429"}, message: "throttled (429)", throttled: true},
+		{name: "server busy", err: &Error{Kind: ErrServerBusy, Message: "busy"}, message: "server busy: busy", throttled: true},
+		{name: "fault", err: &Error{Kind: ErrFault}, message: "request failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.err.Error(); got != test.message {
+				t.Errorf("message = %q, want %q", got, test.message)
+			}
+			if !errors.Is(test.err, test.err.Kind) {
+				t.Errorf("error does not unwrap to its kind %s", test.err.Kind)
+			}
+			if got := errors.Is(test.err, source.ErrNoTenant); got != test.noTenant {
+				t.Errorf("no tenant = %t, want %t", got, test.noTenant)
+			}
+			if got := errors.Is(test.err, source.ErrThrottled); got != test.throttled {
+				t.Errorf("throttled = %t, want %t", got, test.throttled)
+			}
+		})
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "status code error", err: &session.StatusCodeError{StatusCode: http.StatusTooManyRequests}, want: http.StatusTooManyRequests},
+		{name: "other error", err: errors.New("connection reset")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := statusCode(test.err); got != test.want {
+				t.Errorf("status code = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package adfsmetadata
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+	"github.com/upmux/tenantfinder/pkg/source/aad"
+	"github.com/upmux/tenantfinder/pkg/source/idp"
+)
+
+// MetadataPath is where adfs publishes the federation metadata of the farm
+const MetadataPath = "/FederationMetadata/2007-06/FederationMetadata.xml"
+
+// expiryWarning is how long before its expiry a certificate is reported as expiring
+const expiryWarning = 30 * 24 * time.Hour
+
+// EntityDescriptor is the federation metadata of an adfs farm
+type EntityDescriptor struct {
+	EntityID          string           `xml:"entityID,attr"`
+	RoleDescriptors   []RoleDescriptor `xml:"RoleDescriptor"`
+	SPSSODescriptors  []SSODescriptor  `xml:"SPSSODescriptor"`
+	IDPSSODescriptors []SSODescriptor  `xml:"IDPSSODescriptor"`
+}
+
+// RoleDescriptor describes the WS-Federation security token service and
+// application service of the farm
+type RoleDescriptor struct {
+	KeyDescriptors                []KeyDescriptor     `xml:"KeyDescriptor"`
+	ClaimTypes                    []ClaimType         `xml:"ClaimTypesOffered>ClaimType"`
+	SecurityTokenServiceEndpoints []EndpointReference `xml:"SecurityTokenServiceEndpoint>EndpointReference"`
+	PassiveRequestorEndpoints     []EndpointReference `xml:"PassiveRequestorEndpoint>EndpointReference"`
+	ApplicationServiceEndpoints   []EndpointReference `xml:"ApplicationServiceEndpoint>EndpointReference"`
+}
+
+// SSODescriptor describes the SAML identity provider or service provider of the farm
+type SSODescriptor struct {
+	KeyDescriptors             []KeyDescriptor `xml:"KeyDescriptor"`
+	SingleSignOnServices       []Service       `xml:"SingleSignOnService"`
+	SingleLogoutServices       []Service       `xml:"SingleLogoutService"`
+	ArtifactResolutionServices []Service       `xml:"ArtifactResolutionService"`
+	AssertionConsumerServices  []Service       `xml:"AssertionConsumerService"`
+}
+
+type KeyDescriptor struct {
+	Use          string   `xml:"use,attr"`
+	Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+}
+
+type ClaimType struct {
+	Uri         string `xml:"Uri,attr"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type EndpointReference struct {
+	Address string `xml:"Address"`
+}
+
+type Service struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// Source parses the federation metadata of the adfs farms a domain is
+// federated with, reporting their entity id, certificates, endpoints and
+// claim types along with the hosts other than the farm the metadata leaks
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		providers, err := idp.Providers(ctx, sess, domain)
+		if err != nil {
+			s.SendError(results, s.Name(), fmt.Errorf("failed to find adfs farms: %w", err))
+			return
+		}
+
+		farms := make(map[string]struct{})
+		for _, provider := range providers {
+			if provider.Product != idp.ProductADFS || provider.Host == "" {
+				continue
+			}
+			if _, ok := farms[provider.Host]; ok {
+				continue
+			}
+			farms[provider.Host] = struct{}{}
+
+			metadataURL := "https://" + provider.Host + MetadataPath
+			metadata, err := s.fetchMetadata(ctx, sess, metadataURL)
+			if err != nil {
+				s.SendError(results, s.Name(), fmt.Errorf("failed to fetch federation metadata of %s: %w", provider.Host, err))
+				continue
+			}
+			s.report(metadata, provider.Host, metadataURL, results)
+		}
+
+		if len(farms) == 0 {
+			s.SendError(results, s.Name(), fmt.Errorf("%s is not federated with adfs: %w", domain, source.ErrNoResults))
+		}
+	}()
+
+	return results
+}
+
+func (s *Source) fetchMetadata(ctx context.Context, sess *session.Session, metadataURL string) (*EntityDescriptor, error) {
+	resp, err := sess.SimpleGet(ctx, metadataURL)
+	if err != nil {
+		sess.DiscardHTTPResponse(resp)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var metadata EntityDescriptor
+	if err := xml.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	return &metadata, nil
+}
+
+// report sends the results parsed from the metadata of the farm
+func (s *Source) report(metadata *EntityDescriptor, farm, metadataURL string, results chan<- source.Result) {
+	seen := make(map[source.Result]struct{})
+	send := func(resultType source.ResultType, value, reference string) {
+		result := source.Result{Source: s.Name(), Type: resultType, Value: value, Reference: reference}
+		if _, ok := seen[result]; ok {
+			return
+		}
+		seen[result] = struct{}{}
+		results <- result
+		s.Results++
+	}
+
+	hosts := make(map[string]struct{})
+	addHost := func(location string) {
+		if parsed, err := url.Parse(location); err == nil && parsed.Hostname() != "" {
+			hosts[strings.ToLower(parsed.Hostname())] = struct{}{}
+		}
+	}
+
+	if metadata.EntityID != "" {
+		send(source.EntityID, metadata.EntityID, metadataURL)
+		addHost(metadata.EntityID)
+	}
+
+	var keyDescriptors []KeyDescriptor
+	for _, role := range metadata.RoleDescriptors {
+		keyDescriptors = append(keyDescriptors, role.KeyDescriptors...)
+		for _, claimType := range role.ClaimTypes {
+			send(source.ClaimType, claimType.Uri, claimType.DisplayName)
+		}
+		for _, endpoints := range []struct {
+			name       string
+			references []EndpointReference
+		}{
+			{"SecurityTokenServiceEndpoint", role.SecurityTokenServiceEndpoints},
+			{"PassiveRequestorEndpoint", role.PassiveRequestorEndpoints},
+			{"ApplicationServiceEndpoint", role.ApplicationServiceEndpoints},
+		} {
+			for _, reference := range endpoints.references {
+				send(source.Endpoint, reference.Address, endpoints.name)
+				addHost(reference.Address)
+			}
+		}
+	}
+
+	for _, descriptor := range append(metadata.IDPSSODescriptors, metadata.SPSSODescriptors...) {
+		keyDescriptors = append(keyDescriptors, descriptor.KeyDescriptors...)
+		for _, services := range []struct {
+			name     string
+			services []Service
+		}{
+			{"SingleSignOnService", descriptor.SingleSignOnServices},
+			{"SingleLogoutService", descriptor.SingleLogoutServices},
+			{"ArtifactResolutionService", descriptor.ArtifactResolutionServices},
+			{"AssertionConsumerService", descriptor.AssertionConsumerServices},
+		} {
+			for _, service := range services.services {
+				reference := services.name
+				if service.Binding != "" {
+					reference += " " + service.Binding
+				}
+				send(source.Endpoint, service.Location, reference)
+				addHost(service.Location)
+			}
+		}
+	}
+
+	for _, descriptor := range keyDescriptors {
+		for _, encoded := range descriptor.Certificates {
+			thumbprint, reference, err := describeCertificate(descriptor.Use, encoded)
+			if err != nil {
+				s.SendError(results, s.Name(), fmt.Errorf("failed to parse certificate of %s: %w", farm, err))
+				continue
+			}
+			send(source.Certificate, thumbprint, reference)
+		}
+	}
+
+	// The endpoints may name hosts other than the farm, such as internal servers
+	delete(hosts, strings.ToLower(farm))
+	for host := range hosts {
+		reference := "adfs metadata of " + farm
+		if isInternal(host) {
+			reference = "internal host in adfs metadata of " + farm
+		}
+		send(source.Hostname, host, reference)
+	}
+}
+
+// describeCertificate returns the sha1 thumbprint of a base64 encoded
+// certificate along with its use, subject, issuer and expiry
+func describeCertificate(use, encoded string) (string, string, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return "", "", err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha1.Sum(der)
+	thumbprint := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if use == "" {
+		use = "signing and encryption"
+	}
+	reference := fmt.Sprintf("%s certificate, subject %s, issuer %s, expires %s",
+		use, certificate.Subject, certificate.Issuer, certificate.NotAfter.UTC().Format(time.DateOnly))
+	switch remaining := time.Until(certificate.NotAfter); {
+	case remaining <= 0:
+		reference += " (expired)"
+	case remaining <= expiryWarning:
+		reference += " (expiring)"
+	}
+	return thumbprint, reference, nil
+}
+
+// isInternal reports whether a host cannot be reached from the internet,
+// being a private address or a name outside the public dns
+func isInternal(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+	}
+	if !strings.Contains(host, ".") {
+		return true
+	}
+	suffix, icann := publicsuffix.PublicSuffix(host)
+	// Names under no known suffix, such as corp.local, fall back to their last label
+	return !icann && !strings.Contains(suffix, ".")
+}
+
+func (s *Source) Name() string {
+	return "adfs-metadata"
+}
+
+// Dependencies returns idp, whose identity providers the source looks up,
+// and aad, whose federation information they are told from
+func (s *Source) Dependencies() []string {
+	return []string{idp.SourceName, aad.SourceName}
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
@@ -0,0 +1,115 @@
+package adfsmetadata
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate expiring at notAfter
+func testCertificate(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ADFS Signing - sts.contoso.com"},
+		NotBefore:    notAfter.AddDate(-1, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %s", err)
+	}
+	return der
+}
+
+func TestDescribeCertificate(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name     string
+		use      string
+		notAfter time.Time
+		wrap     bool
+		suffix   string
+	}{
+		{name: "valid signing certificate", use: "signing", notAfter: now.AddDate(1, 0, 0)},
+		{name: "certificate without use", notAfter: now.AddDate(1, 0, 0)},
+		{name: "expiring certificate", use: "encryption", notAfter: now.Add(expiryWarning / 2), suffix: " (expiring)"},
+		{name: "expired certificate", use: "signing", notAfter: now.AddDate(0, 0, -1), suffix: " (expired)"},
+		{name: "certificate wrapped over lines", use: "signing", notAfter: now.AddDate(1, 0, 0), wrap: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			der := testCertificate(t, test.notAfter)
+			encoded := base64.StdEncoding.EncodeToString(der)
+			if test.wrap {
+				encoded = "\n  " + encoded[:32] + "\n  " + encoded[32:] + "\n"
+			}
+
+			thumbprint, reference, err := describeCertificate(test.use, encoded)
+			if err != nil {
+				t.Fatalf("could not describe certificate: %s", err)
+			}
+
+			sum := sha1.Sum(der)
+			if want := strings.ToUpper(hex.EncodeToString(sum[:])); thumbprint != want {
+				t.Errorf("thumbprint = %s, want %s", thumbprint, want)
+			}
+			use := test.use
+			if use == "" {
+				use = "signing and encryption"
+			}
+			want := use + " certificate, subject CN=ADFS Signing - sts.contoso.com, issuer CN=ADFS Signing - sts.contoso.com, expires " +
+				test.notAfter.Format(time.DateOnly) + test.suffix
+			if reference != want {
+				t.Errorf("reference = %q, want %q", reference, want)
+			}
+		})
+	}
+}
+
+func TestDescribeCertificateInvalid(t *testing.T) {
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("not a certificate"))} {
+		if _, _, err := describeCertificate("signing", encoded); err == nil {
+			t.Errorf("describing %q succeeded, want an error", encoded)
+		}
+	}
+}
+
+func TestIsInternal(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "sts.contoso.com", want: false},
+		{host: "sts.contoso.co.uk", want: false},
+		{host: "20.190.128.1", want: false},
+		{host: "10.0.0.5", want: true},
+		{host: "192.168.1.10", want: true},
+		{host: "127.0.0.1", want: true},
+		{host: "169.254.10.1", want: true},
+		{host: "fd00::1", want: true},
+		{host: "adfs01", want: true},
+		{host: "adfs.corp.local", want: true},
+		{host: "adfs.contoso.internal", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if got := isInternal(test.host); got != test.want {
+				t.Errorf("internal = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package autodiscover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/gologger"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// Endpoint is the Exchange Online autodiscover v2 service, which redirects
+// the domains it does not serve to their own autodiscover host
+const Endpoint = "https://outlook.office365.com/autodiscover/autodiscover.json/v1.0/"
+
+// mailbox is the local part of the synthetic mailbox asked for, autodiscover
+// answers with the endpoints of the domain whether the mailbox exists or not
+const mailbox = "tenantfinder"
+
+// Protocols asked for, in the casing the service expects
+var protocols = []string{"EWS", "ActiveSync", "Rest"}
+
+// Deployments of the mailboxes of a domain
+const (
+	DeploymentOnline = "exchange-online"
+	DeploymentOnPrem = "on-premises"
+	DeploymentHybrid = "hybrid"
+)
+
+// onlineSuffixes are the hosts of the Exchange Online endpoints
+var onlineSuffixes = []string{".office365.com", ".outlook.com", ".office.com"}
+
+// Response is the answer of the autodiscover v2 service
+type Response struct {
+	Protocol     string `json:"Protocol"`
+	Url          string `json:"Url"`
+	ErrorCode    string `json:"ErrorCode"`
+	ErrorMessage string `json:"ErrorMessage"`
+}
+
+// Source asks the autodiscover v2 service for the endpoints serving the
+// mailboxes of a domain, telling Exchange Online apart from on-premises servers
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		var online, onPrem bool
+		var lastErr error
+		for _, protocol := range protocols {
+			endpoint, err := s.fetchEndpoint(ctx, sess, domain, protocol)
+			if err != nil {
+				gologger.Debug().Msgf("Autodiscover did not return the %s endpoint of %s: %s\n", protocol, domain, err)
+				lastErr = err
+				continue
+			}
+
+			results <- source.Result{
+				Source:    s.Name(),
+				Type:      source.Endpoint,
+				Value:     endpoint,
+				Reference: protocol,
+			}
+			s.Results++
+
+			if isOnline(endpoint) {
+				online = true
+			} else {
+				onPrem = true
+			}
+		}
+
+		switch {
+		case online && onPrem:
+			results <- source.Result{Source: s.Name(), Type: source.Deployment, Value: DeploymentHybrid}
+		case online:
+			results <- source.Result{Source: s.Name(), Type: source.Deployment, Value: DeploymentOnline}
+		case onPrem:
+			results <- source.Result{Source: s.Name(), Type: source.Deployment, Value: DeploymentOnPrem}
+		case lastErr != nil:
+			s.SendError(results, s.Name(), fmt.Errorf("failed to fetch endpoints: %w", lastErr))
+		}
+	}()
+
+	return results
+}
+
+// fetchEndpoint returns the url of the endpoint serving a protocol for the domain
+func (s *Source) fetchEndpoint(ctx context.Context, sess *session.Session, domain, protocol string) (string, error) {
+	requestURL := Endpoint + url.PathEscape(mailbox+"@"+domain) + "?Protocol=" + protocol
+
+	resp, err := sess.Get(ctx, requestURL, "", map[string]string{"Accept": "application/json"})
+	if err != nil {
+		sess.DiscardHTTPResponse(resp)
+		if notServed(err) {
+			return "", fmt.Errorf("no autodiscover service for %s: %w", domain, source.ErrNoTenant)
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var response Response
+	if err := jsoniter.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if response.ErrorCode != "" {
+		return "", fmt.Errorf("%s: %s", response.ErrorCode, response.ErrorMessage)
+	}
+	if response.Url == "" {
+		return "", fmt.Errorf("no %s endpoint for %s: %w", protocol, domain, source.ErrNoTenant)
+	}
+	return response.Url, nil
+}
+
+// notServed reports whether the request failed because no autodiscover
+// service serves the domain, such as when the redirect leads to a host
+// that does not exist
+func notServed(err error) bool {
+	var statusCodeErr *session.StatusCodeError
+	if errors.As(err, &statusCodeErr) {
+		return statusCodeErr.StatusCode == http.StatusNotFound
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// isOnline reports whether the endpoint is hosted by Exchange Online
+func isOnline(endpoint string) bool {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, suffix := range onlineSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Source) Name() string {
+	return "autodiscover"
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
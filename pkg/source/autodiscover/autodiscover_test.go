@@ -0,0 +1,135 @@
+package autodiscover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// TestSourceReplay runs the source against the autodiscover responses
+// recorded in testdata/autodiscover.har
+func TestSourceReplay(t *testing.T) {
+	tests := []struct {
+		domain   string
+		want     []source.Result
+		noTenant bool
+	}{
+		{
+			domain: "contoso.com",
+			want: []source.Result{
+				{Type: source.Endpoint, Value: "https://outlook.office365.com/EWS/Exchange.asmx", Reference: "EWS"},
+				{Type: source.Endpoint, Value: "https://outlook.office365.com/Microsoft-Server-ActiveSync", Reference: "ActiveSync"},
+				{Type: source.Endpoint, Value: "https://outlook.office.com/api", Reference: "Rest"},
+				{Type: source.Deployment, Value: DeploymentOnline},
+			},
+		},
+		{
+			domain: "fabrikam.com",
+			want: []source.Result{
+				{Type: source.Endpoint, Value: "https://mail.fabrikam.com/EWS/Exchange.asmx", Reference: "EWS"},
+				{Type: source.Endpoint, Value: "https://mail.fabrikam.com/Microsoft-Server-ActiveSync", Reference: "ActiveSync"},
+				{Type: source.Endpoint, Value: "https://outlook.office.com/api", Reference: "Rest"},
+				{Type: source.Deployment, Value: DeploymentHybrid},
+			},
+		},
+		{
+			domain: "tailspintoys.com",
+			want: []source.Result{
+				{Type: source.Endpoint, Value: "https://owa.tailspintoys.com/EWS/Exchange.asmx", Reference: "EWS"},
+				{Type: source.Endpoint, Value: "https://owa.tailspintoys.com/Microsoft-Server-ActiveSync", Reference: "ActiveSync"},
+				{Type: source.Deployment, Value: DeploymentOnPrem},
+			},
+		},
+		{
+			domain:   "example.org",
+			noTenant: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			replay, err := session.LoadHARReplay("testdata/autodiscover.har")
+			if err != nil {
+				t.Fatalf("could not load har: %s", err)
+			}
+			sess := session.NewSession(test.domain, "", nil, 5)
+			sess.SetHARReplay(replay)
+
+			s := &Source{}
+			ctx := context.WithValue(context.Background(), session.CtxSourceArg, s.Name())
+
+			var got []source.Result
+			var noTenant bool
+			for result := range s.Run(ctx, test.domain, sess) {
+				if result.Type == source.Error {
+					if !errors.Is(result.Error, source.ErrNoTenant) {
+						t.Fatalf("unexpected error: %s", result.Error)
+					}
+					noTenant = true
+					continue
+				}
+				got = append(got, source.Result{Type: result.Type, Value: result.Value, Reference: result.Reference})
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("results = %+v, want %+v", got, test.want)
+			}
+			if noTenant != test.noTenant {
+				t.Errorf("no tenant = %t, want %t", noTenant, test.noTenant)
+			}
+			if count := s.Statistics().Errors; count != 0 {
+				t.Errorf("errors = %d, want 0", count)
+			}
+		})
+	}
+}
+
+func TestIsOnline(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     bool
+	}{
+		{endpoint: "https://outlook.office365.com/EWS/Exchange.asmx", want: true},
+		{endpoint: "https://OUTLOOK.OFFICE.COM/api", want: true},
+		{endpoint: "https://outlook.com:443/mapi", want: false},
+		{endpoint: "https://eur.outlook.com/mapi", want: true},
+		{endpoint: "https://mail.contoso.com/EWS/Exchange.asmx", want: false},
+		{endpoint: "https://office365.com.contoso.com/EWS", want: false},
+		{endpoint: "://invalid", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			if got := isOnline(test.endpoint); got != test.want {
+				t.Errorf("online = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNotServed(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "not found", err: &session.StatusCodeError{StatusCode: http.StatusNotFound}, want: true},
+		{name: "wrapped not found", err: fmt.Errorf("autodiscover: %w", &session.StatusCodeError{StatusCode: http.StatusNotFound}), want: true},
+		{name: "server error", err: &session.StatusCodeError{StatusCode: http.StatusInternalServerError}},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "autodiscover.contoso.com", IsNotFound: true}, want: true},
+		{name: "dns timeout", err: &net.DNSError{Err: "i/o timeout", Name: "autodiscover.contoso.com", IsTimeout: true}},
+		{name: "other error", err: errors.New("connection reset")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := notServed(test.err); got != test.want {
+				t.Errorf("not served = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
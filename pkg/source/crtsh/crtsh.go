@@ -0,0 +1,167 @@
+package crtsh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+	"github.com/upmux/tenantfinder/pkg/source/aad"
+)
+
+// searchURL searches the certificate transparency logs indexed by crt.sh
+const searchURL = "https://crt.sh/?q=%s&output=json"
+
+// maxResponseSize bounds the certificates read for a domain, which run into
+// hundreds of megabytes for the largest tenants
+const maxResponseSize = 64 << 20
+
+// References telling the domains of the tenant apart from the subdomains
+// found in the certificate transparency logs
+const (
+	ReferenceTenantDomain = "tenant domain"
+	// ReferenceSubdomain is followed by the tenant domain the subdomain belongs to
+	ReferenceSubdomain = "ct subdomain of "
+)
+
+// certificate is a certificate logged for a searched domain
+type certificate struct {
+	CommonName string `json:"common_name"`
+	NameValue  string `json:"name_value"`
+}
+
+// Source collects the subdomains of every domain of the tenant of the
+// input from certificate transparency logs
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		response, err := aad.FederationInformation(ctx, sess, domain)
+		if err != nil {
+			s.SendError(results, s.Name(), fmt.Errorf("failed to fetch tenant domains: %w", err))
+			return
+		}
+
+		seen := make(map[string]struct{})
+		var tenantDomains []string
+		for _, tenantDomain := range response.Domains.Domain {
+			tenantDomain = strings.ToLower(tenantDomain)
+			seen[tenantDomain] = struct{}{}
+			tenantDomains = append(tenantDomains, tenantDomain)
+
+			results <- source.Result{
+				Source:    s.Name(),
+				Type:      source.Domain,
+				Value:     tenantDomain,
+				Reference: ReferenceTenantDomain,
+			}
+			s.Results++
+		}
+
+		for _, tenantDomain := range tenantDomains {
+			// The initial domains are covered by the wildcard certificates of Microsoft
+			if strings.HasSuffix(tenantDomain, ".onmicrosoft.com") {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			subdomains, err := s.fetchSubdomains(ctx, sess, tenantDomain)
+			if err != nil {
+				s.SendError(results, s.Name(), fmt.Errorf("failed to search certificates of %s: %w", tenantDomain, err))
+				continue
+			}
+
+			for _, subdomain := range subdomains {
+				if _, ok := seen[subdomain]; ok {
+					continue
+				}
+				seen[subdomain] = struct{}{}
+
+				// Subdomains are hosts of the tenant rather than tenant domains
+				results <- source.Result{
+					Source:    s.Name(),
+					Type:      source.Hostname,
+					Value:     subdomain,
+					Reference: ReferenceSubdomain + tenantDomain,
+				}
+				s.Results++
+			}
+		}
+	}()
+
+	return results
+}
+
+// fetchSubdomains returns the subdomains of the domain named in the certificates logged for it
+func (s *Source) fetchSubdomains(ctx context.Context, sess *session.Session, domain string) ([]string, error) {
+	resp, err := sess.SimpleGet(ctx, fmt.Sprintf(searchURL, url.QueryEscape("%."+domain)))
+	if err != nil {
+		sess.DiscardHTTPResponse(resp)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var certificates []certificate
+	if err := jsoniter.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&certificates); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	var subdomains []string
+	unique := make(map[string]struct{})
+	for _, cert := range certificates {
+		// Certificates with several names list them one per line
+		names := strings.Split(cert.NameValue, "\n")
+		names = append(names, cert.CommonName)
+		for _, name := range names {
+			name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "*.")
+			if !strings.HasSuffix(name, "."+domain) || strings.ContainsAny(name, " @*") {
+				continue
+			}
+			if _, ok := unique[name]; ok {
+				continue
+			}
+			unique[name] = struct{}{}
+			subdomains = append(subdomains, name)
+		}
+	}
+	return subdomains, nil
+}
+
+func (s *Source) Name() string {
+	return "crtsh"
+}
+
+// Dependencies returns aad, whose federation information the source fetches
+func (s *Source) Dependencies() []string {
+	return []string{aad.SourceName}
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
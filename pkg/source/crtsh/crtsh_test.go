@@ -0,0 +1,83 @@
+package crtsh
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// replaySession serves the requests from the federation information and
+// certificates recorded in testdata/crtsh.har
+func replaySession(t *testing.T) *session.Session {
+	t.Helper()
+	replay, err := session.LoadHARReplay("testdata/crtsh.har")
+	if err != nil {
+		t.Fatalf("could not load har: %s", err)
+	}
+	sess := session.NewSession("contoso.com", "", nil, 5)
+	sess.SetHARReplay(replay)
+	return sess
+}
+
+func TestFetchSubdomains(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   []string
+	}{
+		// Wildcards are stripped, names with an @ or a space and names only
+		// ending like the domain are left out, and names are listed once
+		{"contoso.com", []string{"www.contoso.com", "vpn.contoso.com", "mail.contoso.com"}},
+		{"fabrikam.com", []string{"sso.fabrikam.com", "dev.fabrikam.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			s := &Source{}
+			ctx := context.WithValue(context.Background(), session.CtxSourceArg, s.Name())
+
+			got, err := s.fetchSubdomains(ctx, replaySession(t), test.domain)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("fetchSubdomains() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestSourceReplay runs the source against the responses recorded in
+// testdata/crtsh.har, which holds no certificates for the initial domains
+func TestSourceReplay(t *testing.T) {
+	want := []source.Result{
+		{Type: source.Domain, Value: "contoso.com", Reference: ReferenceTenantDomain},
+		{Type: source.Domain, Value: "fabrikam.com", Reference: ReferenceTenantDomain},
+		{Type: source.Domain, Value: "contoso.onmicrosoft.com", Reference: ReferenceTenantDomain},
+		{Type: source.Domain, Value: "contoso.mail.onmicrosoft.com", Reference: ReferenceTenantDomain},
+		{Type: source.Hostname, Value: "www.contoso.com", Reference: ReferenceSubdomain + "contoso.com"},
+		{Type: source.Hostname, Value: "vpn.contoso.com", Reference: ReferenceSubdomain + "contoso.com"},
+		{Type: source.Hostname, Value: "mail.contoso.com", Reference: ReferenceSubdomain + "contoso.com"},
+		{Type: source.Hostname, Value: "sso.fabrikam.com", Reference: ReferenceSubdomain + "fabrikam.com"},
+		{Type: source.Hostname, Value: "dev.fabrikam.com", Reference: ReferenceSubdomain + "fabrikam.com"},
+	}
+
+	s := &Source{}
+	ctx := context.WithValue(context.Background(), session.CtxSourceArg, s.Name())
+
+	var got []source.Result
+	for result := range s.Run(ctx, "contoso.com", replaySession(t)) {
+		if result.Type == source.Error {
+			t.Fatalf("unexpected error: %s", result.Error)
+		}
+		got = append(got, source.Result{Type: result.Type, Value: result.Value, Reference: result.Reference})
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %+v, want %+v", got, want)
+	}
+	if count := s.Statistics().Results; count != len(want) {
+		t.Errorf("results count = %d, want %d", count, len(want))
+	}
+}
@@ -0,0 +1,207 @@
+package dnsfingerprint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// Microsoft 365 services told by the dns records of a domain
+const (
+	ServiceVerified           = "microsoft-365"
+	ServiceExchangeOnline     = "exchange-online"
+	ServiceTeams              = "teams"
+	ServiceDeviceRegistration = "entra-device-registration"
+	ServiceIntune             = "intune"
+	ServiceDKIM               = "exchange-online-dkim"
+)
+
+// verificationPrefix starts the txt record proving the domain was added to a tenant
+const verificationPrefix = "ms=ms"
+
+// mxSuffix ends the mail exchangers of Exchange Online Protection
+const mxSuffix = ".mail.protection.outlook.com"
+
+// cnameRecords are the hosts whose cname into Microsoft tells a service the domain uses
+var cnameRecords = []struct {
+	prefix  string
+	service string
+}{
+	{"autodiscover.", ServiceExchangeOnline},
+	{"lyncdiscover.", ServiceTeams},
+	{"enterpriseregistration.", ServiceDeviceRegistration},
+	{"enterpriseenrollment.", ServiceIntune},
+}
+
+// dkimSelectors are the selectors Exchange Online signs with
+var dkimSelectors = []string{"selector1", "selector2"}
+
+// microsoftSuffixes are the zones of the Microsoft 365 services
+var microsoftSuffixes = []string{
+	".outlook.com",
+	".office365.com",
+	".office.com",
+	".lync.com",
+	".windows.net",
+	".microsoft.com",
+	".microsoftonline.com",
+	".onmicrosoft.com",
+}
+
+// Source fingerprints the Microsoft 365 services a domain uses from its dns
+// records, which works without asking Microsoft and so while it throttles
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		if sess.Resolver == nil {
+			s.SendError(results, s.Name(), errors.New("no dns resolver"))
+			return
+		}
+
+		found, err := s.fingerprint(ctx, sess.Resolver, domain, results)
+		switch {
+		case found > 0:
+			s.Results += found
+		case err != nil:
+			s.SendError(results, s.Name(), fmt.Errorf("failed to query dns records: %w", err))
+		default:
+			s.SendError(results, s.Name(), fmt.Errorf("no microsoft 365 record for %s: %w", domain, source.ErrNoResults))
+		}
+	}()
+
+	return results
+}
+
+// fingerprint sends a result for every record of the domain pointing to
+// Microsoft 365 and returns their number along with the last lookup error
+func (s *Source) fingerprint(ctx context.Context, resolver *resolve.Resolver, domain string, results chan<- source.Result) (int, error) {
+	var found int
+	var lastErr error
+	send := func(resultType source.ResultType, value, reference string) {
+		results <- source.Result{Source: s.Name(), Type: resultType, Value: value, Reference: reference}
+		found++
+	}
+
+	records, err := resolver.TXT(domain)
+	if err != nil {
+		lastErr = err
+	}
+	for _, record := range records {
+		if strings.HasPrefix(strings.ToLower(record), verificationPrefix) {
+			send(source.Service, ServiceVerified, "TXT "+record)
+		}
+	}
+
+	exchangers, err := resolver.MX(domain)
+	if err != nil {
+		lastErr = err
+	}
+	for _, exchanger := range exchangers {
+		exchanger = normalize(exchanger)
+		if strings.HasSuffix(exchanger, mxSuffix) {
+			send(source.Service, ServiceExchangeOnline, "MX "+exchanger)
+		}
+	}
+
+	for _, record := range cnameRecords {
+		if ctx.Err() != nil {
+			return found, ctx.Err()
+		}
+		host := record.prefix + domain
+		targets, err := resolver.CNAME(host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, target := range targets {
+			if target = normalize(target); isMicrosoftHost(target) {
+				send(source.Service, record.service, fmt.Sprintf("CNAME %s %s", host, target))
+			}
+		}
+	}
+
+	for _, selector := range dkimSelectors {
+		if ctx.Err() != nil {
+			return found, ctx.Err()
+		}
+		host := selector + "._domainkey." + domain
+		targets, err := resolver.CNAME(host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, target := range targets {
+			target = normalize(target)
+			if !strings.HasSuffix(target, ".onmicrosoft.com") {
+				continue
+			}
+			reference := fmt.Sprintf("CNAME %s %s", host, target)
+			send(source.Service, ServiceDKIM, reference)
+			// The selectors point into the initial domain of the tenant
+			if initial := initialDomain(target); initial != "" {
+				send(source.Domain, initial, reference)
+			}
+		}
+	}
+	return found, lastErr
+}
+
+// initialDomain returns the <name>.onmicrosoft.com domain a dkim selector points into
+func initialDomain(target string) string {
+	labels := strings.Split(target, ".")
+	if len(labels) < 3 {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-3:], ".")
+}
+
+func isMicrosoftHost(host string) bool {
+	for _, suffix := range microsoftSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func (s *Source) Name() string {
+	return "dns"
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+// NeedsResolver returns true as the source queries dns
+func (s *Source) NeedsResolver(_ *session.Session) bool {
+	return true
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
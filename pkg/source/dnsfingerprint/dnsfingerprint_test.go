@@ -0,0 +1,101 @@
+package dnsfingerprint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/upmux/tenantfinder/pkg/resolve/resolvetest"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+func TestInitialDomain(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "selector1-contoso-com._domainkey.contosocorp.onmicrosoft.com", want: "contosocorp.onmicrosoft.com"},
+		{target: "contoso.onmicrosoft.com", want: "contoso.onmicrosoft.com"},
+		{target: "onmicrosoft.com", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			if got := initialDomain(test.target); got != test.want {
+				t.Errorf("initial domain = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsMicrosoftHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "autodiscover.outlook.com", want: true},
+		{host: "webdir.online.lync.com", want: true},
+		{host: "enterpriseregistration.windows.net", want: true},
+		{host: "manage.microsoft.com", want: true},
+		{host: "autodiscover.contoso.com", want: false},
+		{host: "outlook.com.contoso.com", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if got := isMicrosoftHost(test.host); got != test.want {
+				t.Errorf("microsoft host = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got, want := normalize("Contoso-COM.mail.protection.outlook.com."), "contoso-com.mail.protection.outlook.com"; got != want {
+		t.Errorf("normalized = %q, want %q", got, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	resolver := resolvetest.NewResolver(t, resolvetest.Records{
+		dns.TypeTXT: {"contoso.com": {"MS=ms12345678", "v=spf1 include:spf.protection.outlook.com -all"}},
+		dns.TypeMX:  {"contoso.com": {"contoso-com.mail.protection.outlook.com"}},
+		dns.TypeCNAME: {
+			"autodiscover.contoso.com":         {"autodiscover.outlook.com"},
+			"lyncdiscover.contoso.com":         {"lyncdiscover.contoso-hosting.net"},
+			"enterpriseenrollment.contoso.com": {"enterpriseenrollment.manage.microsoft.com"},
+			"selector1._domainkey.contoso.com": {"selector1-contoso-com._domainkey.contosocorp.onmicrosoft.com"},
+			"selector2._domainkey.contoso.com": {"selector2-contoso-com._domainkey.contoso-mail.net"},
+		},
+	})
+
+	s := &Source{}
+	results := make(chan source.Result)
+	var got []source.Result
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for result := range results {
+			got = append(got, source.Result{Type: result.Type, Value: result.Value, Reference: result.Reference})
+		}
+	}()
+	found, _ := s.fingerprint(context.Background(), resolver, "contoso.com", results)
+	close(results)
+	<-done
+
+	dkim := "CNAME selector1._domainkey.contoso.com selector1-contoso-com._domainkey.contosocorp.onmicrosoft.com"
+	want := []source.Result{
+		{Type: source.Service, Value: ServiceVerified, Reference: "TXT MS=ms12345678"},
+		{Type: source.Service, Value: ServiceExchangeOnline, Reference: "MX contoso-com.mail.protection.outlook.com"},
+		{Type: source.Service, Value: ServiceExchangeOnline, Reference: "CNAME autodiscover.contoso.com autodiscover.outlook.com"},
+		{Type: source.Service, Value: ServiceIntune, Reference: "CNAME enterpriseenrollment.contoso.com enterpriseenrollment.manage.microsoft.com"},
+		{Type: source.Service, Value: ServiceDKIM, Reference: dkim},
+		{Type: source.Domain, Value: "contosocorp.onmicrosoft.com", Reference: dkim},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %+v, want %+v", got, want)
+	}
+	if found != len(want) {
+		t.Errorf("found = %d, want %d", found, len(want))
+	}
+}
@@ -0,0 +1,15 @@
+package source
+
+import "errors"
+
+// Errors matched by the errors of the sources, so the outcome of an input
+// can be told apart from a failure regardless of the source reporting it
+var (
+	// ErrNoTenant is matched when the input does not belong to any tenant
+	ErrNoTenant = errors.New("no tenant")
+	// ErrNoResults is matched when the source found nothing for the input,
+	// which tells nothing about the tenant it belongs to
+	ErrNoResults = errors.New("no results")
+	// ErrThrottled is matched when the source was throttled by the remote end
+	ErrThrottled = errors.New("throttled")
+)
@@ -0,0 +1,184 @@
+package exchangeonprem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+
+	"github.com/upmux/tenantfinder/pkg/resolve"
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+	"github.com/upmux/tenantfinder/pkg/source/aad"
+)
+
+// autodiscoverPath is the path of the autodiscover service on an exchange server
+const autodiscoverPath = "/autodiscover/autodiscover.svc"
+
+// cloudSuffixes are the hosts of Exchange Online, already asked by the aad source
+var cloudSuffixes = []string{
+	".outlook.com",
+	".office365.com",
+	".office.com",
+	".microsoft.com",
+}
+
+// Source asks the autodiscover service of on-premises and hybrid Exchange
+// organizations, found through dns, for the federation information of a domain
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		if sess.Resolver == nil {
+			s.SendError(results, s.Name(), errors.New("no dns resolver"))
+			return
+		}
+
+		endpoint, response, err := s.fetchDomains(ctx, sess, domain)
+		if err != nil {
+			s.SendError(results, s.Name(), fmt.Errorf("failed to fetch domains: %w", err))
+			return
+		}
+
+		for _, domain := range response.Domains.Domain {
+			results <- source.Result{
+				Source:    s.Name(),
+				Type:      source.Domain,
+				Value:     domain,
+				Reference: endpoint,
+			}
+			s.Results++
+		}
+
+		for _, issuer := range response.TokenIssuers.TokenIssuer {
+			results <- source.Result{
+				Source:    s.Name(),
+				Type:      source.Issuer,
+				Value:     issuer.Uri,
+				Reference: issuer.Endpoint,
+			}
+		}
+	}()
+
+	return results
+}
+
+// fetchDomains asks the autodiscover hosts of the domain in turn and returns
+// the response of the first one answering along with its endpoint
+func (s *Source) fetchDomains(ctx context.Context, sess *session.Session, domain string) (string, *aad.Response, error) {
+	hosts := autodiscoverHosts(sess.Resolver, domain)
+	if len(hosts) == 0 {
+		return "", nil, fmt.Errorf("no autodiscover host found for %s: %w", domain, source.ErrNoResults)
+	}
+
+	// Hosts not running exchange fail in all sorts of ways, only the
+	// errors returned by an autodiscover service are worth reporting
+	var serviceErr error
+	for _, host := range hosts {
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+
+		endpoint := "https://" + host + autodiscoverPath
+		response, err := aad.FetchFederationInformation(ctx, sess, endpoint, domain)
+		if err == nil {
+			return endpoint, response, nil
+		}
+		gologger.Debug().Msgf("Autodiscover endpoint %s did not answer for %s: %s\n", endpoint, domain, err)
+
+		var aadErr *aad.Error
+		if errors.As(err, &aadErr) && aadErr.Kind != aad.ErrMalformedResponse {
+			serviceErr = err
+		}
+	}
+	if serviceErr != nil {
+		return "", nil, serviceErr
+	}
+	return "", nil, fmt.Errorf("no autodiscover service answered for %s: %w", domain, source.ErrNoResults)
+}
+
+// autodiscoverHosts returns the hosts that may run the autodiscover service of
+// the domain, in the order clients try them: the targets of the _autodiscover
+// srv record, then the conventional hostnames. Hosts that do not resolve or
+// point to Exchange Online are left out.
+func autodiscoverHosts(resolver *resolve.Resolver, domain string) []string {
+	var candidates []string
+	if targets, err := resolver.SRV("_autodiscover._tcp." + domain); err == nil {
+		candidates = append(candidates, targets...)
+	}
+	candidates = append(candidates, "autodiscover."+domain, domain, "mail."+domain)
+
+	var hosts []string
+	seen := make(map[string]struct{})
+	for _, host := range candidates {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		if _, ok := seen[host]; ok || host == "" {
+			continue
+		}
+		seen[host] = struct{}{}
+
+		if isCloudHost(host) {
+			continue
+		}
+		if cnames, err := resolver.CNAME(host); err == nil && anyCloudHost(cnames) {
+			continue
+		}
+		if addresses, err := resolver.A(host); err != nil || len(addresses) == 0 {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+func isCloudHost(host string) bool {
+	for _, suffix := range cloudSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyCloudHost(hosts []string) bool {
+	for _, host := range hosts {
+		if isCloudHost(strings.TrimSuffix(strings.ToLower(host), ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Source) Name() string {
+	return "exchange-onprem"
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+// NeedsResolver returns true as the source queries dns
+func (s *Source) NeedsResolver(_ *session.Session) bool {
+	return true
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
@@ -0,0 +1,52 @@
+package exchangeonprem
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/upmux/tenantfinder/pkg/resolve/resolvetest"
+)
+
+func TestAutodiscoverHosts(t *testing.T) {
+	resolver := resolvetest.NewResolver(t, resolvetest.Records{
+		dns.TypeSRV: {
+			"_autodiscover._tcp.contoso.com":  {"Mail.Contoso.com.", "autodiscover-s.outlook.com"},
+			"_autodiscover._tcp.fabrikam.com": {"autodiscover.fabrikam.com"},
+		},
+		dns.TypeCNAME: {
+			"autodiscover.contoso.com":    {"autodiscover.outlook.com"},
+			"autodiscover.fabrikam.com":   {"exchange.fabrikam.com"},
+			"autodiscover.tailspin.com":   {"autodiscover.outlook.com"},
+			"mail.tailspin.com":           {"outlook.office365.com"},
+			"autodiscover.wingtiptoy.com": {"autodiscover.outlook.com"},
+		},
+		dns.TypeA: {
+			"mail.contoso.com":          {"192.0.2.1"},
+			"contoso.com":               {"192.0.2.2"},
+			"autodiscover.contoso.com":  {"192.0.2.3"},
+			"autodiscover.fabrikam.com": {"192.0.2.4"},
+			"tailspin.com":              {"192.0.2.5"},
+		},
+	})
+
+	tests := []struct {
+		domain string
+		want   []string
+	}{
+		// The srv targets come first, the Exchange Online ones and the duplicates left out
+		{domain: "contoso.com", want: []string{"mail.contoso.com", "contoso.com"}},
+		// A cname to an on-premises host is kept
+		{domain: "fabrikam.com", want: []string{"autodiscover.fabrikam.com"}},
+		{domain: "tailspin.com", want: []string{"tailspin.com"}},
+		{domain: "wingtiptoy.com", want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			if got := autodiscoverHosts(resolver, test.domain); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("autodiscover hosts = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+	"github.com/upmux/tenantfinder/pkg/source/mailcorrelation"
+)
+
+// Google Workspace services told by the records and login of a domain
+const (
+	ServiceWorkspace = "google-workspace"
+	ServiceSSO       = "google-workspace-sso"
+)
+
+// LoginEndpoint is the Workspace login page of a domain, which sends the
+// domains signing in through a third party identity provider to it
+const LoginEndpoint = "https://www.google.com/a/%s/ServiceLogin"
+
+// verificationPrefix starts the txt record proving the domain to Google
+const verificationPrefix = "google-site-verification="
+
+// spfInclude is the spf include authorizing the Workspace mail servers
+const spfInclude = "include:_spf.google.com"
+
+// mxSuffixes end the mail exchangers of Workspace, aspmx.l.google.com and
+// its alternates as well as the smtp.google.com of newer domains
+var mxSuffixes = []string{".google.com", ".googlemail.com"}
+
+// googleDomains are the zones of the Google login pages
+var googleDomains = []string{"google.com", "gstatic.com", "youtube.com"}
+
+// identityProviders are the identity providers serving many organizations,
+// whose domain says nothing about the one signing in through them
+var identityProviders = map[string]struct{}{
+	"okta.com": {}, "oktapreview.com": {}, "okta-emea.com": {}, "onelogin.com": {}, "pingidentity.com": {},
+	"pingone.com": {}, "pingone.eu": {}, "microsoftonline.com": {}, "windows.net": {}, "duosecurity.com": {},
+	"auth0.com": {}, "jumpcloud.com": {}, "cyberark.cloud": {}, "idaptive.app": {}, "secureauth.com": {},
+	"salesforce.com": {}, "force.com": {},
+}
+
+// Source detects the domains using Google Workspace from their dns records
+// and login redirect, and follows the organization-level signals of the
+// domain to its other domains
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		if sess.Resolver == nil {
+			s.SendError(results, s.Name(), errors.New("no dns resolver"))
+			return
+		}
+
+		send := func(resultType source.ResultType, value, reference string) {
+			results <- source.Result{Source: s.Name(), Type: resultType, Value: value, Reference: reference}
+			s.Results++
+		}
+
+		found, err := s.fingerprint(sess, domain, send)
+
+		identityProvider, loginErr := s.identityProvider(ctx, sess, domain)
+		if loginErr != nil {
+			gologger.Debug().Msgf("Could not follow the Google Workspace login of %s: %s\n", domain, loginErr)
+		} else if identityProvider != "" {
+			send(source.Service, ServiceSSO, "login redirect to "+identityProvider)
+			found++
+		}
+
+		switch {
+		case found == 0 && err != nil:
+			s.SendError(results, s.Name(), fmt.Errorf("failed to query dns records: %w", err))
+			return
+		case found == 0:
+			s.SendError(results, s.Name(), fmt.Errorf("no google workspace record for %s: %w", domain, source.ErrNoResults))
+			return
+		}
+
+		// The identity provider hosted on a domain of its own points to the organization
+		if candidate := registrableDomain(identityProvider); candidate != "" && candidate != registrableDomain(domain) {
+			if _, ok := identityProviders[candidate]; !ok {
+				send(source.RelatedDomain, candidate, fmt.Sprintf("google workspace of %s signs in at %s", domain, identityProvider))
+			}
+		}
+
+		candidates, _ := mailcorrelation.Candidates(ctx, sess, domain)
+		for _, candidate := range candidates {
+			send(source.RelatedDomain, candidate.Domain, candidate.Reference)
+		}
+	}()
+
+	return results
+}
+
+// fingerprint sends a result for every dns record of the domain telling it
+// uses Google Workspace and returns their number along with the last lookup error
+func (s *Source) fingerprint(sess *session.Session, domain string, send func(source.ResultType, string, string)) (int, error) {
+	var found int
+	var lastErr error
+
+	exchangers, err := sess.Resolver.MX(domain)
+	if err != nil {
+		lastErr = err
+	}
+	for _, exchanger := range exchangers {
+		exchanger = strings.TrimSuffix(strings.ToLower(exchanger), ".")
+		for _, suffix := range mxSuffixes {
+			if strings.HasSuffix(exchanger, suffix) {
+				send(source.Service, ServiceWorkspace, "MX "+exchanger)
+				found++
+				break
+			}
+		}
+	}
+
+	records, err := sess.Resolver.TXT(domain)
+	if err != nil {
+		lastErr = err
+	}
+	for _, record := range records {
+		lower := strings.ToLower(record)
+		switch {
+		case strings.HasPrefix(lower, verificationPrefix):
+			send(source.Service, ServiceWorkspace, "TXT "+record)
+			found++
+		case strings.HasPrefix(lower, "v=spf1") && strings.Contains(lower, spfInclude):
+			send(source.Service, ServiceWorkspace, "SPF "+spfInclude)
+			found++
+		}
+	}
+	return found, lastErr
+}
+
+// identityProvider follows the Workspace login of the domain and returns the
+// host it ends on when that is a third party identity provider rather than Google
+func (s *Source) identityProvider(ctx context.Context, sess *session.Session, domain string) (string, error) {
+	resp, err := sess.SimpleGet(ctx, fmt.Sprintf(LoginEndpoint, url.PathEscape(domain)))
+	defer sess.DiscardHTTPResponse(resp)
+
+	// The identity provider may refuse the request, the redirect to it still tells it
+	var host string
+	var urlErr *url.Error
+	switch {
+	case resp != nil && resp.Request != nil:
+		host = resp.Request.URL.Hostname()
+	case errors.As(err, &urlErr):
+		if parsed, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			host = parsed.Hostname()
+		}
+	}
+	if host == "" || isGoogleHost(host) {
+		return "", err
+	}
+	return strings.ToLower(host), nil
+}
+
+func isGoogleHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, zone := range googleDomains {
+		if host == zone || strings.HasSuffix(host, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+func registrableDomain(host string) string {
+	if host == "" {
+		return ""
+	}
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(strings.ToLower(host), "."))
+	if err != nil {
+		return ""
+	}
+	return registrable
+}
+
+func (s *Source) Name() string {
+	return "google"
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+// NeedsResolver returns true as the source queries dns
+func (s *Source) NeedsResolver(_ *session.Session) bool {
+	return true
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
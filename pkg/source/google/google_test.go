@@ -0,0 +1,59 @@
+package google
+
+import (
+	"context"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+)
+
+// TestIdentityProvider follows the Workspace logins recorded in testdata/login.har
+func TestIdentityProvider(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{domain: "contoso.com", want: "sts.contoso-corp.com"},
+		// The redirect tells the identity provider even when it cannot be reached
+		{domain: "fabrikam.com", want: "fabrikam.okta.com"},
+		// Signing in at Google itself tells no identity provider
+		{domain: "tailspintoys.com", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			replay, err := session.LoadHARReplay("testdata/login.har")
+			if err != nil {
+				t.Fatalf("could not load har: %s", err)
+			}
+			sess := session.NewSession(test.domain, "", nil, 5)
+			sess.SetHARReplay(replay)
+
+			s := &Source{}
+			ctx := context.WithValue(context.Background(), session.CtxSourceArg, s.Name())
+			got, _ := s.identityProvider(ctx, sess, test.domain)
+			if got != test.want {
+				t.Errorf("identity provider = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsGoogleHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "accounts.google.com", want: true},
+		{host: "SSL.GSTATIC.COM", want: true},
+		{host: "google.com", want: true},
+		{host: "google.com.contoso.com", want: false},
+		{host: "notgoogle.com", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if got := isGoogleHost(test.host); got != test.want {
+				t.Errorf("google host = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package idp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/gologger"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+	"github.com/upmux/tenantfinder/pkg/source/aad"
+)
+
+// RealmEndpoint tells how the users of a domain sign in to Entra ID, giving
+// the sign-in url of the identity provider of federated domains
+const RealmEndpoint = "https://login.microsoftonline.com/getuserrealm.srf?json=1&login="
+
+// mailbox is the local part of the synthetic user asked for, the realm
+// depends on the domain only
+const mailbox = "tenantfinder"
+
+// Namespace types of the domains signing in elsewhere and with Entra ID
+const (
+	namespaceFederated = "Federated"
+	namespaceManaged   = "Managed"
+)
+
+// Authentication types of a domain
+const (
+	AuthenticationManaged   = "managed"
+	AuthenticationFederated = "federated"
+)
+
+// SourceName is the name of the source, under which the shared lookup of the
+// identity providers is rate limited and throttled whoever runs it
+const SourceName = "idp"
+
+// Identity provider products
+const (
+	ProductADFS    = "adfs"
+	ProductOkta    = "okta"
+	ProductPing    = "ping"
+	ProductDuo     = "duo"
+	ProductUnknown = "unknown"
+)
+
+// products tell the identity provider product from the zone of its host,
+// or from the path of its endpoints for the products hosted on premises
+var products = []struct {
+	product string
+	zones   []string
+	paths   []string
+}{
+	{ProductOkta, []string{"okta.com", "oktapreview.com", "okta-emea.com", "okta-gov.com"}, nil},
+	{ProductPing, []string{"pingone.com", "pingone.eu", "pingone.asia", "pingidentity.com"}, []string{"/idp/"}},
+	{ProductDuo, []string{"duosecurity.com"}, []string{"/dag/"}},
+	{ProductADFS, nil, []string{"/adfs/"}},
+}
+
+// microsoftZones are the zones of Entra ID, which every tenant reports
+var microsoftZones = []string{"microsoftonline.com", "microsoftonline.us", "windows.net", "live.com"}
+
+// microsoftIssuer is the token issuer of Entra ID, which every tenant reports
+const microsoftIssuer = "urn:federation:MicrosoftOnline"
+
+// Realm is the answer of the user realm service
+type Realm struct {
+	NameSpaceType       string `json:"NameSpaceType"`
+	DomainName          string `json:"DomainName"`
+	AuthURL             string `json:"AuthURL"`
+	FederationBrandName string `json:"FederationBrandName"`
+}
+
+// Provider is a third party identity provider a domain is federated with
+type Provider struct {
+	// Product is the identity provider product, such as adfs or okta
+	Product string
+	// Host is the host of the identity provider, such as corp.okta.com or
+	// the farm name of adfs, empty when only the product is known
+	Host string
+	// Evidence is the issuer or sign-in url the provider was told from
+	Evidence string
+}
+
+// Source identifies the third party identity providers a domain is federated
+// with from its token issuers and the sign-in url of its realm
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		providers, err := Providers(ctx, sess, domain)
+		if err != nil {
+			s.SendError(results, s.Name(), fmt.Errorf("failed to identify identity providers: %w", err))
+			return
+		}
+
+		// Managed domains belong to a tenant but sign in with Entra ID itself
+		if len(providers) == 0 {
+			if authentication, signIn, err := Authentication(ctx, sess, domain); err == nil {
+				results <- source.Result{
+					Source:    s.Name(),
+					Type:      source.Authentication,
+					Value:     authentication,
+					Reference: signIn,
+				}
+			}
+			return
+		}
+
+		for _, provider := range providers {
+			results <- source.Result{
+				Source:    s.Name(),
+				Type:      source.IdentityProvider,
+				Value:     provider.Product,
+				Reference: provider.Evidence,
+			}
+			s.Results++
+
+			if provider.Host != "" {
+				results <- source.Result{
+					Source:    s.Name(),
+					Type:      source.Hostname,
+					Value:     provider.Host,
+					Reference: provider.Product,
+				}
+				s.Results++
+			}
+		}
+	}()
+
+	return results
+}
+
+// Providers returns the third party identity providers the domain is
+// federated with, none for the domains managed by Entra ID. They are identified
+// once per enumeration, whichever source asks first, and the sources asking
+// for them list idp and aad among their dependencies.
+func Providers(ctx context.Context, sess *session.Session, domain string) ([]Provider, error) {
+	providers, err := sess.Shared("idp:"+domain, func() (interface{}, error) {
+		return identify(context.WithValue(ctx, session.CtxSourceArg, SourceName), sess, domain)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return providers.([]Provider), nil
+}
+
+// identify tells the identity providers from the token issuers of the tenant
+// and the sign-in url of the realm of the domain
+func identify(ctx context.Context, sess *session.Session, domain string) ([]Provider, error) {
+	var evidence []string
+
+	response, err := aad.FederationInformation(ctx, sess, domain)
+	if err != nil {
+		gologger.Debug().Msgf("Could not fetch the token issuers of %s: %s\n", domain, err)
+	} else {
+		for _, issuer := range response.TokenIssuers.TokenIssuer {
+			evidence = append(evidence, issuer.Uri, issuer.Endpoint)
+		}
+	}
+
+	realm, realmErr := UserRealm(ctx, sess, domain)
+	if realmErr == nil && realm.NameSpaceType == namespaceFederated {
+		evidence = append(evidence, realm.AuthURL)
+	}
+
+	var providers []Provider
+	seen := make(map[Provider]struct{})
+	for _, value := range evidence {
+		provider, ok := classify(value)
+		if !ok {
+			continue
+		}
+		key := Provider{Product: provider.Product, Host: provider.Host}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		providers = append(providers, provider)
+	}
+	providers = withoutBareProducts(providers)
+
+	if len(providers) == 0 {
+		// The realm tells whether the domain belongs to a tenant whatever the issuers
+		if realmErr != nil {
+			return nil, fmt.Errorf("failed to fetch realm: %w", realmErr)
+		}
+		if realm.NameSpaceType != namespaceManaged && realm.NameSpaceType != namespaceFederated {
+			return nil, fmt.Errorf("%s does not belong to a tenant: %w", domain, source.ErrNoTenant)
+		}
+	}
+	return providers, nil
+}
+
+// UserRealm returns the realm of the domain. It is fetched once per enumeration,
+// whichever source asks first, and rate limited and throttled as idp.
+func UserRealm(ctx context.Context, sess *session.Session, domain string) (*Realm, error) {
+	realm, err := sess.Shared("realm:"+domain, func() (interface{}, error) {
+		return FetchRealm(context.WithValue(ctx, session.CtxSourceArg, SourceName), sess, domain)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return realm.(*Realm), nil
+}
+
+// Authentication returns whether the users of the domain sign in with Entra ID
+// or a federated identity provider, along with the sign-in url of the latter
+func Authentication(ctx context.Context, sess *session.Session, domain string) (string, string, error) {
+	realm, err := UserRealm(ctx, sess, domain)
+	if err != nil {
+		return "", "", err
+	}
+	switch realm.NameSpaceType {
+	case namespaceFederated:
+		return AuthenticationFederated, realm.AuthURL, nil
+	case namespaceManaged:
+		return AuthenticationManaged, "", nil
+	}
+	return "", "", fmt.Errorf("%s does not belong to a tenant: %w", domain, source.ErrNoTenant)
+}
+
+// FetchRealm asks the user realm service how the users of the domain sign in
+func FetchRealm(ctx context.Context, sess *session.Session, domain string) (*Realm, error) {
+	resp, err := sess.Get(ctx, RealmEndpoint+url.QueryEscape(mailbox+"@"+domain), "", map[string]string{"Accept": "application/json"})
+	if err != nil {
+		sess.DiscardHTTPResponse(resp)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var realm Realm
+	if err := jsoniter.NewDecoder(resp.Body).Decode(&realm); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &realm, nil
+}
+
+// classify tells the identity provider product from an issuer or sign-in
+// url, leaving out the issuers of Entra ID itself
+func classify(value string) (Provider, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil || parsed.Host == "" {
+		// Issuers may be urns, such as urn:federation:MicrosoftOnline
+		return Provider{}, false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	path := strings.ToLower(parsed.Path)
+	if inZones(host, microsoftZones) {
+		return Provider{}, false
+	}
+
+	provider := Provider{Product: ProductUnknown, Host: host, Evidence: value}
+	for _, candidate := range products {
+		if inZones(host, candidate.zones) || hasAnyPrefix(path, candidate.paths) {
+			provider.Product = candidate.product
+			break
+		}
+	}
+
+	// Issuers of hosted products name the vendor, such as http://www.okta.com/<id>
+	for _, candidate := range products {
+		for _, zone := range candidate.zones {
+			if host == zone || host == "www."+zone {
+				provider.Host = ""
+			}
+		}
+	}
+	return provider, true
+}
+
+// IsMicrosoftIssuer reports whether a token issuer is Entra ID itself, which
+// every tenant trusts, rather than a third party identity provider
+func IsMicrosoftIssuer(uri string) bool {
+	uri = strings.TrimSpace(uri)
+	if strings.EqualFold(uri, microsoftIssuer) {
+		return true
+	}
+	parsed, err := url.Parse(uri)
+	return err == nil && inZones(strings.ToLower(parsed.Hostname()), microsoftZones)
+}
+
+// withoutBareProducts leaves out the providers known by their product only
+// when another evidence gave the host of the same product
+func withoutBareProducts(providers []Provider) []Provider {
+	hosted := make(map[string]struct{})
+	for _, provider := range providers {
+		if provider.Host != "" {
+			hosted[provider.Product] = struct{}{}
+		}
+	}
+	kept := providers[:0]
+	for _, provider := range providers {
+		if _, ok := hosted[provider.Product]; ok && provider.Host == "" {
+			continue
+		}
+		kept = append(kept, provider)
+	}
+	return kept
+}
+
+func inZones(host string, zones []string) bool {
+	for _, zone := range zones {
+		if host == zone || strings.HasSuffix(host, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Source) Name() string {
+	return SourceName
+}
+
+// Dependencies returns aad, whose federation information the source fetches
+func (s *Source) Dependencies() []string {
+	return []string{aad.SourceName}
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
@@ -0,0 +1,108 @@
+package idp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  Provider
+		ok    bool
+	}{
+		{
+			name:  "adfs farm",
+			value: "http://sts.contoso.com/adfs/services/trust",
+			want:  Provider{Product: ProductADFS, Host: "sts.contoso.com", Evidence: "http://sts.contoso.com/adfs/services/trust"},
+			ok:    true,
+		},
+		{
+			name:  "okta org",
+			value: "https://contoso.okta.com/app/office365/exk1/sso/wsfed/passive",
+			want:  Provider{Product: ProductOkta, Host: "contoso.okta.com", Evidence: "https://contoso.okta.com/app/office365/exk1/sso/wsfed/passive"},
+			ok:    true,
+		},
+		{
+			name:  "okta issuer names the vendor only",
+			value: "http://www.okta.com/exk1",
+			want:  Provider{Product: ProductOkta, Evidence: "http://www.okta.com/exk1"},
+			ok:    true,
+		},
+		{
+			name:  "ping on premises",
+			value: "https://sso.contoso.com/idp/prp.wsf",
+			want:  Provider{Product: ProductPing, Host: "sso.contoso.com", Evidence: "https://sso.contoso.com/idp/prp.wsf"},
+			ok:    true,
+		},
+		{
+			name:  "duo access gateway",
+			value: "https://dag.contoso.com/dag/saml2/idp/SSOService.php",
+			want:  Provider{Product: ProductDuo, Host: "dag.contoso.com", Evidence: "https://dag.contoso.com/dag/saml2/idp/SSOService.php"},
+			ok:    true,
+		},
+		{
+			name:  "unknown provider",
+			value: " https://login.contoso.com/wsfed ",
+			want:  Provider{Product: ProductUnknown, Host: "login.contoso.com", Evidence: " https://login.contoso.com/wsfed "},
+			ok:    true,
+		},
+		{name: "entra id", value: "https://sts.windows.net/00000000-0000-0000-0000-000000000000/"},
+		{name: "entra id sign in", value: "https://login.microsoftonline.com/common/oauth2"},
+		{name: "urn issuer", value: "urn:federation:MicrosoftOnline"},
+		{name: "empty", value: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := classify(test.value)
+			if ok != test.ok {
+				t.Fatalf("classified = %t, want %t", ok, test.ok)
+			}
+			if got != test.want {
+				t.Errorf("provider = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithoutBareProducts(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []Provider
+		want      []Provider
+	}{
+		{
+			name:      "bare product dropped for the hosted one",
+			providers: []Provider{{Product: ProductOkta}, {Product: ProductOkta, Host: "contoso.okta.com"}},
+			want:      []Provider{{Product: ProductOkta, Host: "contoso.okta.com"}},
+		},
+		{
+			name:      "bare product kept alone",
+			providers: []Provider{{Product: ProductOkta}, {Product: ProductADFS, Host: "sts.contoso.com"}},
+			want:      []Provider{{Product: ProductOkta}, {Product: ProductADFS, Host: "sts.contoso.com"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := withoutBareProducts(test.providers); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("providers = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsMicrosoftIssuer(t *testing.T) {
+	tests := map[string]bool{
+		"urn:federation:MicrosoftOnline":              true,
+		"https://sts.windows.net/contoso/":            true,
+		"http://adfs.contoso.com/adfs/services/trust": false,
+		"http://www.okta.com/exk1a2b3c4d5e6f7g8h9":    false,
+		"urn:federation:contoso":                      false,
+	}
+	for issuer, want := range tests {
+		if got := IsMicrosoftIssuer(issuer); got != want {
+			t.Errorf("IsMicrosoftIssuer(%q) = %t, want %t", issuer, got, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package mailcorrelation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/upmux/tenantfinder/pkg/emailsec"
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+)
+
+// sharedDomains are the email providers, security gateways and dmarc
+// report processors serving many organizations, so linking to them says
+// nothing about ownership
+var sharedDomains = map[string]struct{}{
+	// Email providers and gateways
+	"outlook.com": {}, "microsoft.com": {}, "office365.com": {}, "google.com": {}, "googlemail.com": {},
+	"zoho.com": {}, "zoho.eu": {}, "icloud.com": {}, "yahoo.com": {}, "secureserver.net": {}, "emailsrvr.com": {},
+	"mimecast.com": {}, "pphosted.com": {}, "ppe-hosted.com": {}, "proofpoint.com": {}, "messagelabs.com": {},
+	"barracudanetworks.com": {}, "iphmx.com": {}, "cisco.com": {}, "sophos.com": {}, "trendmicro.com": {},
+	"hornetsecurity.com": {}, "fireeyecloud.com": {}, "spamhaus.org": {},
+	// Sending services
+	"amazonses.com": {}, "sendgrid.net": {}, "mailgun.org": {}, "mailgun.com": {}, "mandrillapp.com": {},
+	"mailchimp.com": {}, "mcsv.net": {}, "rsgsv.net": {}, "exacttarget.com": {}, "salesforce.com": {},
+	"sparkpostmail.com": {}, "mailjet.com": {}, "sendinblue.com": {}, "brevo.com": {}, "postmarkapp.com": {},
+	"constantcontact.com": {}, "hubspotemail.net": {}, "hubspot.com": {}, "mktomail.com": {}, "marketo.com": {},
+	"zendesk.com": {}, "freshdesk.com": {}, "servicenow.com": {}, "service-now.com": {}, "atlassian.net": {},
+	"successfactors.com": {}, "qualtrics.com": {}, "docusign.net": {}, "smtp.com": {}, "sendpulse.com": {},
+	// Dmarc report processors
+	"agari.com": {}, "dmarcian.com": {}, "dmarcian.eu": {}, "valimail.com": {}, "ondmarc.com": {},
+	"redsift.cloud": {}, "easydmarc.com": {}, "easydmarc.us": {}, "easydmarc.eu": {}, "dmarcanalyzer.com": {},
+	"uriports.com": {}, "dmarc.report": {}, "fraudmarc.com": {}, "dmarcadvisor.com": {}, "mailhardener.com": {},
+	"sendmarc.com": {}, "powerdmarc.com": {}, "cloudflare.net": {}, "kdmarc.com": {}, "returnpath.net": {},
+	"250ok.com": {}, "glockapps.com": {}, "dmarc25.com": {}, "mxtoolbox.com": {},
+}
+
+// Source follows the spf includes and dmarc reporting addresses of a domain
+// to the other domains of the organization, as org-specific include domains
+// and shared reporting mailboxes are strong ownership signals
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		if sess.Resolver == nil {
+			s.SendError(results, s.Name(), errors.New("no dns resolver"))
+			return
+		}
+
+		candidates, found := Candidates(ctx, sess, domain)
+		for _, candidate := range candidates {
+			results <- source.Result{Source: s.Name(), Type: source.RelatedDomain, Value: candidate.Domain, Reference: candidate.Reference}
+			s.Results++
+		}
+		if !found {
+			s.SendError(results, s.Name(), fmt.Errorf("no spf or dmarc record for %s: %w", domain, source.ErrNoResults))
+		}
+	}()
+
+	return results
+}
+
+// Candidate is a domain linked to another by its mail records
+type Candidate struct {
+	Domain    string
+	Reference string
+}
+
+// Candidates returns the domains the spf includes and dmarc reporting
+// addresses of a domain link to, found is false when it has neither record
+func Candidates(ctx context.Context, sess *session.Session, domain string) (candidates []Candidate, found bool) {
+	seen := map[string]struct{}{registrableDomain(domain): {}}
+	add := func(candidate, reference string) {
+		if _, ok := seen[candidate]; ok {
+			return
+		}
+		seen[candidate] = struct{}{}
+		candidates = append(candidates, Candidate{Domain: candidate, Reference: reference})
+	}
+
+	checker := emailsec.NewChecker(sess.Resolver, nil)
+	spf := checker.SPF(domain)
+	if spf != nil {
+		for _, include := range spf.Includes {
+			if candidate := candidateDomain(include); candidate != "" {
+				add(candidate, fmt.Sprintf("spf of %s includes %s", domain, include))
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return candidates, spf != nil
+	}
+	dmarc := checker.DMARC(domain)
+	if dmarc != nil {
+		for _, report := range []struct {
+			tag       string
+			addresses []string
+		}{{"rua", dmarc.RUA}, {"ruf", dmarc.RUF}} {
+			for _, address := range report.addresses {
+				_, mailboxDomain, ok := strings.Cut(address, "@")
+				if !ok {
+					continue
+				}
+				candidate := candidateDomain(mailboxDomain)
+				if candidate == "" {
+					continue
+				}
+				reference := fmt.Sprintf("dmarc of %s reports to %s (%s)", domain, address, report.tag)
+				// The destination of external reports authorizes the domains it receives reports for
+				if authorized(sess, domain, mailboxDomain) {
+					reference += ", authorized by " + domain + "._report._dmarc." + mailboxDomain
+				}
+				add(candidate, reference)
+			}
+		}
+	}
+	return candidates, spf != nil || dmarc != nil
+}
+
+// authorized reports whether the destination of external dmarc reports
+// publishes the record accepting the reports of the domain
+func authorized(sess *session.Session, domain, destination string) bool {
+	records, err := sess.Resolver.TXT(domain + "._report._dmarc." + destination)
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		if strings.HasPrefix(strings.ToLower(record), "v=dmarc1") {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateDomain returns the registrable domain of a linked host, or an
+// empty string when it belongs to a provider shared by many organizations
+func candidateDomain(host string) string {
+	registrable := registrableDomain(host)
+	if registrable == "" {
+		return ""
+	}
+	if _, ok := sharedDomains[registrable]; ok {
+		return ""
+	}
+	return registrable
+}
+
+func registrableDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return ""
+	}
+	return registrable
+}
+
+func (s *Source) Name() string {
+	return "mail-correlation"
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+// NeedsResolver returns true as the source queries dns
+func (s *Source) NeedsResolver(_ *session.Session) bool {
+	return true
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
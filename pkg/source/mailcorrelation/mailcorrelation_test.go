@@ -0,0 +1,75 @@
+package mailcorrelation
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/upmux/tenantfinder/pkg/resolve/resolvetest"
+	"github.com/upmux/tenantfinder/pkg/session"
+)
+
+func TestCandidateDomain(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "_spf.contoso-mail.com", want: "contoso-mail.com"},
+		{host: "Reports.Contoso.co.uk.", want: "contoso.co.uk"},
+		{host: "spf.protection.outlook.com", want: ""},
+		{host: "_spf.google.com", want: ""},
+		{host: "rua.agari.com", want: ""},
+		{host: "mxa-00123.gslb.pphosted.com", want: ""},
+		{host: "co.uk", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if got := candidateDomain(test.host); got != test.want {
+				t.Errorf("candidate domain = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	resolver := resolvetest.NewResolver(t, resolvetest.Records{dns.TypeTXT: {
+		"contoso.com":        {"v=spf1 include:spf.protection.outlook.com include:_spf.contoso-mail.com include:_spf.contoso.com include:sendgrid.net -all"},
+		"_dmarc.contoso.com": {"v=DMARC1; p=reject; rua=mailto:dmarc@contoso-reports.com,mailto:x@rua.agari.com; ruf=mailto:dmarc@contoso-reports.com"},
+		"contoso.com._report._dmarc.contoso-reports.com": {"v=DMARC1"},
+		"_dmarc.fabrikam.com":                            {"v=DMARC1; p=none; rua=mailto:reports@dmarcian.com"},
+	}})
+
+	tests := []struct {
+		domain    string
+		want      []Candidate
+		wantFound bool
+	}{
+		{
+			domain: "contoso.com",
+			want: []Candidate{
+				{Domain: "contoso-mail.com", Reference: "spf of contoso.com includes _spf.contoso-mail.com"},
+				{Domain: "contoso-reports.com", Reference: "dmarc of contoso.com reports to dmarc@contoso-reports.com (rua), authorized by contoso.com._report._dmarc.contoso-reports.com"},
+			},
+			wantFound: true,
+		},
+		// Only shared report processors, which say nothing about ownership
+		{domain: "fabrikam.com", want: nil, wantFound: true},
+		{domain: "tailspin.com", want: nil, wantFound: false},
+	}
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			sess := session.NewSession(test.domain, "", nil, 5)
+			sess.Resolver = resolver
+
+			got, found := Candidates(context.Background(), sess, test.domain)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("candidates = %+v, want %+v", got, test.want)
+			}
+			if found != test.wantFound {
+				t.Errorf("found = %t, want %t", found, test.wantFound)
+			}
+		})
+	}
+}
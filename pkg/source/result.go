@@ -14,4 +14,52 @@ const (
 	Url ResultType = iota
 	Error
 	Domain
+	Issuer
+	// Endpoint is a service endpoint answering for the input, the reference names its protocol
+	Endpoint
+	// Deployment tells where the mailboxes of the input are hosted
+	Deployment
+	// Service is a Microsoft 365 service the input uses, the reference gives the evidence
+	Service
+	// Tenant is the name of the tenant, the reference gives its initial domain
+	Tenant
+	// Hostname is a hostname of the tenant, the reference names its service or where it was found
+	Hostname
+	// IdentityProvider is the product of a third party identity provider, the reference gives the evidence
+	IdentityProvider
+	// EntityID is the entity id of a federation service, the reference gives its metadata url
+	EntityID
+	// Certificate is the thumbprint of a federation certificate, the reference describes it
+	Certificate
+	// ClaimType is a claim type a federation service offers, the reference gives its display name
+	ClaimType
+	// Authentication tells whether the input signs in with Entra ID or a federated
+	// identity provider, the reference gives the sign-in url of the latter
+	Authentication
+	// RelatedDomain is a domain linked to the input without proving it belongs to
+	// the tenant, such as an spf include domain, the reference gives the link
+	RelatedDomain
 )
+
+var resultTypeNames = map[ResultType]string{
+	Url:              "url",
+	Error:            "error",
+	Domain:           "domain",
+	Issuer:           "issuer",
+	Endpoint:         "endpoint",
+	Deployment:       "deployment",
+	Service:          "service",
+	Tenant:           "tenant",
+	Hostname:         "hostname",
+	IdentityProvider: "identity-provider",
+	EntityID:         "entity-id",
+	Certificate:      "certificate",
+	ClaimType:        "claim-type",
+	Authentication:   "authentication",
+	RelatedDomain:    "related-domain",
+}
+
+// String returns the name of the result type written in the output
+func (t ResultType) String() string {
+	return resultTypeNames[t]
+}
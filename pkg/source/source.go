@@ -26,3 +26,18 @@ type Source interface {
 	// Statistics returns the scrapping statistics for the source
 	Statistics() Statistics
 }
+
+// Dependent is implemented by the sources running the shared lookups of other
+// sources, which are rate limited and throttled under the name of their owner
+type Dependent interface {
+	// Dependencies returns the names of the sources whose lookups the source runs
+	Dependencies() []string
+}
+
+// Resolving is implemented by the sources querying dns, the resolver of the
+// session is only created when one of the sources of a run needs it
+type Resolving interface {
+	// NeedsResolver returns true if the source queries dns through the session,
+	// which may depend on the options of the session
+	NeedsResolver(sess *session.Session) bool
+}
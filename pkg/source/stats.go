@@ -1,11 +1,53 @@
 package source
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 // Statistics contains statistics about the scraping process
 type Statistics struct {
 	TimeTaken time.Duration
 	Errors    int
 	Results   int
+	Throttled int
 	Skipped   bool
 }
+
+// Counters counts the results and errors of the last run of a source, which
+// the sources embed to report their statistics
+type Counters struct {
+	timeTaken time.Duration
+	Errors    int
+	Results   int
+}
+
+// Reset clears the counters at the start of a run
+func (c *Counters) Reset() {
+	c.Errors = 0
+	c.Results = 0
+}
+
+// Finish records the time taken by the run started at startTime
+func (c *Counters) Finish(startTime time.Time) {
+	c.timeTaken = time.Since(startTime)
+}
+
+// SendError sends the error of a source and counts it as a failure. An input
+// without tenant, or without anything for the source to find, is an answer
+// rather than a failure and is not counted.
+func (c *Counters) SendError(results chan<- Result, name string, err error) {
+	results <- Result{Source: name, Type: Error, Error: err}
+	if !errors.Is(err, ErrNoTenant) && !errors.Is(err, ErrNoResults) {
+		c.Errors++
+	}
+}
+
+// Statistics returns the statistics of the last run
+func (c *Counters) Statistics() Statistics {
+	return Statistics{
+		Errors:    c.Errors,
+		Results:   c.Results,
+		TimeTaken: c.timeTaken,
+	}
+}
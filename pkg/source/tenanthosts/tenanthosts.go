@@ -0,0 +1,187 @@
+package tenanthosts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+	"github.com/upmux/tenantfinder/pkg/source"
+	"github.com/upmux/tenantfinder/pkg/source/aad"
+)
+
+// initialSuffix ends the initial domain every tenant is created with
+const initialSuffix = ".onmicrosoft.com"
+
+// hostnames are the well-known hostnames of a tenant, derived from its name
+var hostnames = []struct {
+	format  string
+	service string
+}{
+	{"%s.sharepoint.com", "sharepoint"},
+	{"%s-my.sharepoint.com", "onedrive"},
+	{"%s-admin.sharepoint.com", "sharepoint-admin"},
+	{"%s.mail.onmicrosoft.com", "exchange-online"},
+	{"%s.crm.dynamics.com", "dynamics"},
+	{"%s.webhook.office.com", "teams"},
+}
+
+// domainHostnames are the hostnames of every verified domain of the tenant
+// that Teams and Skype for Business clients look up to sign in
+var domainHostnames = []struct {
+	format  string
+	service string
+}{
+	{"lyncdiscover.%s", "lync"},
+	{"sip.%s", "lync"},
+}
+
+// derivedHost is a hostname derived from the tenant along with its service
+type derivedHost struct {
+	host    string
+	service string
+}
+
+// Source identifies the name of the tenant from its initial
+// <name>.onmicrosoft.com domain and derives its well-known hostnames
+type Source struct {
+	source.Counters
+}
+
+func (s *Source) Run(ctx context.Context, domain string, sess *session.Session) <-chan source.Result {
+	results := make(chan source.Result)
+	s.Reset()
+
+	go func() {
+		defer func(startTime time.Time) {
+			s.Finish(startTime)
+			close(results)
+		}(time.Now())
+
+		if !sess.NoVerifyHosts && sess.Resolver == nil {
+			s.SendError(results, s.Name(), errors.New("no dns resolver"))
+			return
+		}
+
+		response, err := aad.FederationInformation(ctx, sess, domain)
+		if err != nil {
+			s.SendError(results, s.Name(), fmt.Errorf("failed to fetch tenant domains: %w", err))
+			return
+		}
+
+		domains := response.Domains.Domain
+		for _, initialDomain := range initialDomains(domains) {
+			name := strings.TrimSuffix(initialDomain, initialSuffix)
+			results <- source.Result{
+				Source:    s.Name(),
+				Type:      source.Tenant,
+				Value:     name,
+				Reference: initialDomain,
+			}
+			s.Results++
+
+			for _, derived := range nameHosts(name) {
+				if !s.send(ctx, sess, derived, results) {
+					return
+				}
+			}
+		}
+		for _, derived := range domainHosts(domains) {
+			if !s.send(ctx, sess, derived, results) {
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+// send writes the derived hostname unless it is verified and does not
+// resolve, it returns false once the context is done
+func (s *Source) send(ctx context.Context, sess *session.Session, derived derivedHost, results chan<- source.Result) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if !sess.NoVerifyHosts {
+		if addresses, err := sess.Resolver.A(derived.host); err != nil || len(addresses) == 0 {
+			return true
+		}
+	}
+	results <- source.Result{
+		Source:    s.Name(),
+		Type:      source.Hostname,
+		Value:     derived.host,
+		Reference: derived.service,
+	}
+	s.Results++
+	return true
+}
+
+// nameHosts returns the hostnames derived from the name of the tenant
+func nameHosts(name string) []derivedHost {
+	hosts := make([]derivedHost, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		hosts = append(hosts, derivedHost{host: fmt.Sprintf(hostname.format, name), service: hostname.service})
+	}
+	return hosts
+}
+
+// domainHosts returns the hostnames derived from the verified domains of the
+// tenant, leaving out its onmicrosoft.com domains which clients never sign in with
+func domainHosts(domains []string) []derivedHost {
+	var hosts []derivedHost
+	seen := make(map[string]struct{})
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if _, ok := seen[domain]; ok || strings.HasSuffix(domain, initialSuffix) {
+			continue
+		}
+		seen[domain] = struct{}{}
+		for _, hostname := range domainHostnames {
+			hosts = append(hosts, derivedHost{host: fmt.Sprintf(hostname.format, domain), service: hostname.service})
+		}
+	}
+	return hosts
+}
+
+// initialDomains returns the <name>.onmicrosoft.com domains of a tenant,
+// leaving out the mail routing <name>.mail.onmicrosoft.com ones
+func initialDomains(domains []string) []string {
+	var initial []string
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if strings.HasSuffix(domain, initialSuffix) && strings.Count(domain, ".") == 2 {
+			initial = append(initial, domain)
+		}
+	}
+	return initial
+}
+
+func (s *Source) Name() string {
+	return "tenant-hosts"
+}
+
+// Dependencies returns aad, whose federation information the source fetches
+func (s *Source) Dependencies() []string {
+	return []string{aad.SourceName}
+}
+
+func (s *Source) IsDefault() bool {
+	return false
+}
+
+// NeedsResolver returns true unless the hostnames the source derives are
+// returned without checking they resolve
+func (s *Source) NeedsResolver(sess *session.Session) bool {
+	return !sess.NoVerifyHosts
+}
+
+func (s *Source) NeedsKey() bool {
+	return false
+}
+
+func (s *Source) AddApiKeys(_ []string) {
+	// No API keys needed
+}
@@ -0,0 +1,78 @@
+package tenanthosts
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/upmux/tenantfinder/pkg/session"
+)
+
+func TestInitialDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		want    []string
+	}{
+		{
+			name:    "initial domain",
+			domains: []string{"contoso.com", "Contoso.onmicrosoft.com", "contoso.mail.onmicrosoft.com"},
+			want:    []string{"contoso.onmicrosoft.com"},
+		},
+		{
+			name:    "renamed tenant",
+			domains: []string{"contoso.onmicrosoft.com", "contosoltd.onmicrosoft.com"},
+			want:    []string{"contoso.onmicrosoft.com", "contosoltd.onmicrosoft.com"},
+		},
+		{
+			name:    "no initial domain",
+			domains: []string{"contoso.com", "contoso.mail.onmicrosoft.com"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := initialDomains(test.domains); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("initial domains = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNeedsResolver(t *testing.T) {
+	for _, noVerifyHosts := range []bool{false, true} {
+		sess := &session.Session{NoVerifyHosts: noVerifyHosts}
+		if got := (&Source{}).NeedsResolver(sess); got == noVerifyHosts {
+			t.Errorf("needs resolver with no verify hosts %t = %t, want %t", noVerifyHosts, got, !noVerifyHosts)
+		}
+	}
+}
+
+func TestDomainHosts(t *testing.T) {
+	got := domainHosts([]string{"contoso.com", "Contoso.com", "contoso.onmicrosoft.com", "contoso.mail.onmicrosoft.com", "fabrikam.com"})
+	want := []derivedHost{
+		{host: "lyncdiscover.contoso.com", service: "lync"},
+		{host: "sip.contoso.com", service: "lync"},
+		{host: "lyncdiscover.fabrikam.com", service: "lync"},
+		{host: "sip.fabrikam.com", service: "lync"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("domain hosts = %v, want %v", got, want)
+	}
+}
+
+func TestNameHosts(t *testing.T) {
+	services := make(map[string]string)
+	for _, derived := range nameHosts("contoso") {
+		services[derived.service] = derived.host
+	}
+	for service, want := range map[string]string{
+		"sharepoint":      "contoso.sharepoint.com",
+		"onedrive":        "contoso-my.sharepoint.com",
+		"exchange-online": "contoso.mail.onmicrosoft.com",
+		"dynamics":        "contoso.crm.dynamics.com",
+		"teams":           "contoso.webhook.office.com",
+	} {
+		if got := services[service]; got != want {
+			t.Errorf("%s hostname = %q, want %q", service, got, want)
+		}
+	}
+}